@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// AlphaOptions controls how cells that are only partly covered by the subject
+// are drawn.  Coverage comes from content that implements CoverageContent.
+type AlphaOptions struct {
+	// Cells with less coverage than this, from 0 to 1, get no circle.  The
+	// default is 0.5.
+	Threshold *float64 `json:"threshold,omitempty"`
+
+	// Fade shrinks the area of each circle by its coverage so that the edges
+	// of the subject fade out.  A low Threshold works best with this.
+	Fade bool `json:"fade,omitempty"`
+}
+
+// Validate checks the alpha options.
+func (ao *AlphaOptions) Validate() error {
+	if t := ao.threshold(); t < 0 || t > 1 {
+		return fmt.Errorf("alpha threshold %g is outside of the range 0 to 1", t)
+	}
+	return nil
+}
+
+func (ao *AlphaOptions) threshold() float64 {
+	if ao == nil || ao.Threshold == nil {
+		return 0.5
+	}
+	return *ao.Threshold
+}
+
+// apply adjusts radius r for a cell with the given coverage.
+func (ao *AlphaOptions) apply(r, coverage float64) float64 {
+	if r == noCircle || coverage < ao.threshold() {
+		return noCircle
+	}
+	if ao != nil && ao.Fade {
+		return r * math.Sqrt(coverage)
+	}
+	return r
+}
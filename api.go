@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// apiError writes err as a JSON error response.
+func apiError(w http.ResponseWriter, err error, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// handleAPIRender renders an image for other programs.  It takes a multipart
+// form with the image in "image" and, optionally, a JSON job in "job" that
+// is applied on top of the defaults.  The result is the SVG or, with
+// ?format=zip, a ZIP holding the SVG, a PNG preview and stats.json.
+func (s *server) handleAPIRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, fmt.Errorf("POST a multipart form with an image and a job"), http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "svg" && format != "zip" {
+		apiError(w, fmt.Errorf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	if err := r.ParseMultipartForm(s.maxUpload); err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "too large") {
+			status = http.StatusRequestEntityTooLarge
+		}
+		apiError(w, err, status)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	job := NewJob()
+	if js := r.FormValue("job"); js != "" {
+		if err := json.Unmarshal([]byte(js), job); err != nil {
+			apiError(w, fmt.Errorf("error parsing job: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	f, hdr, err := r.FormFile("image")
+	if err != nil {
+		apiError(w, fmt.Errorf("no image: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	d, err := ioutil.ReadAll(f)
+	if err != nil {
+		apiError(w, err, http.StatusBadRequest)
+		return
+	}
+	job.Input = filepath.Base(hdr.Filename)
+	name := strings.TrimSuffix(job.Input, filepath.Ext(job.Input))
+	sum := sha256.Sum256(d)
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	var out bytes.Buffer
+	var stats *Stats
+	err = s.run(ctx, func(ctx context.Context) error {
+		img, err := s.decodeImage(d)
+		if err != nil {
+			return fmt.Errorf("can't read %s: %v", hdr.Filename, err)
+		}
+		sg, circles, err := s.renderImage(ctx, job, img)
+		if err != nil {
+			return err
+		}
+		sg.Meta = &Metadata{Generator: "circle-art", Job: job, InputSHA256: hex.EncodeToString(sum[:])}
+		stats = NewStats(sg, circles)
+		svg := sg.MarshalSVG(circles, name)
+		if format != "zip" {
+			out.Write(svg)
+			return nil
+		}
+		if err := s.checkPreview(sg, zipPreviewPPI); err != nil {
+			return err
+		}
+		return writeZip(&out, name, svg, sg, circles, stats)
+	})
+	if err != nil {
+		apiError(w, err, statusFor(err))
+		return
+	}
+
+	w.Header().Set("X-Circles", strconv.Itoa(stats.Circles))
+	w.Header().Set("X-Skipped", strconv.Itoa(stats.Skipped))
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+	} else {
+		w.Header().Set("Content-Type", "image/svg+xml")
+	}
+	w.Write(out.Bytes())
+}
+
+// zipPreviewPPI is the resolution of the PNG preview in a ZIP.
+const zipPreviewPPI = 100
+
+// writeZip writes a ZIP holding <name>.svg, <name>.png and stats.json to buf.
+func writeZip(buf *bytes.Buffer, name string, svg []byte, sg *SVGGrid, circles []Circle, stats *Stats) error {
+	zw := zip.NewWriter(buf)
+
+	fw, err := zw.Create(name + ".svg")
+	if err != nil {
+		return err
+	}
+	fw.Write(svg)
+
+	fw, err = zw.Create(name + ".png")
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(fw, RenderPreview(circles, sg.canvasArea(), zipPreviewPPI)); err != nil {
+		return err
+	}
+
+	fw, err = zw.Create("stats.json")
+	if err != nil {
+		return err
+	}
+	d, _ := json.MarshalIndent(stats, "", "  ")
+	fw.Write(d)
+
+	return zw.Close()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jbeda/geom"
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// Caption is a line of text, such as a title, a date or a signature, that is
+// engraved in the margin of the canvas or on the board beside it.  It is drawn
+// with a single stroke font so it is scored as lines rather than filled.
+type Caption struct {
+	// The text.  "{date}" is replaced with the date of the render, and the
+	// text with the date filled in is what is recorded in the SVG, so that a
+	// rerender keeps the same date.
+	Text string `json:"text"`
+
+	// The height of capitals in inches.  The default is half the canvas
+	// margin.
+	Size float64 `json:"size,omitempty"`
+
+	// "bottom" (default) or "top".
+	Edge string `json:"edge,omitempty"`
+
+	// "left" (default), "center" or "right".  Left and right captions line up
+	// with the inside of the canvas margin.
+	Align string `json:"align,omitempty"`
+
+	// If set, the caption goes on the board outside the canvas instead of in
+	// its margin.
+	Outside bool `json:"outside,omitempty"`
+}
+
+// captionDescent is how far below the baseline the font goes, in capital
+// heights.
+const captionDescent = 0.15
+
+// Validate checks that the caption can be drawn and fits where it goes.
+func (c *Caption) Validate(p Params) error {
+	if strings.TrimSpace(c.Text) == "" {
+		return fmt.Errorf("a caption needs some text")
+	}
+	if err := checkStrokeText(c.Text); err != nil {
+		return err
+	}
+	if c.Size < 0 {
+		return fmt.Errorf("caption size must not be negative")
+	}
+	switch c.Edge {
+	case "", "bottom", "top":
+	default:
+		return fmt.Errorf("unknown caption edge %q", c.Edge)
+	}
+	switch c.Align {
+	case "", "left", "center", "right":
+	default:
+		return fmt.Errorf("unknown caption align %q", c.Align)
+	}
+
+	size := c.size(p)
+	if w := strokeTextWidth(c.Text) * size; w > p.canvasInsideWidth() {
+		return fmt.Errorf("caption %q is %.2fin wide but there is only %.2fin", c.Text, w, p.canvasInsideWidth())
+	}
+	room := p.CanvasMargin
+	if c.Outside {
+		room = (p.BoardHeight-p.CanvasHeight)/2 - c.gap(p)
+	}
+	if h := size * (1 + captionDescent); h > room {
+		return fmt.Errorf("caption %q is %.2fin high but there is only %.2fin", c.Text, h, room)
+	}
+	return nil
+}
+
+func (c *Caption) size(p Params) float64 {
+	if c.Size == 0 {
+		return p.CanvasMargin / 2
+	}
+	return c.Size
+}
+
+// gap returns the space between the canvas and a caption outside it.
+func (c *Caption) gap(p Params) float64 {
+	return c.size(p) / 2
+}
+
+// fillCaptionDates replaces "{date}" in each caption of job with the date of
+// now.  It is done once, before the job is rendered and recorded.
+func fillCaptionDates(job *Job, now time.Time) {
+	for i := range job.Captions {
+		c := &job.Captions[i]
+		c.Text = strings.Replace(c.Text, "{date}", now.Format("2006-01-02"), -1)
+	}
+}
+
+// strokes returns the lines that draw the caption, in inches on the board, for
+// a canvas whose top left is at (xOffset, yOffset).
+func (c *Caption) strokes(p Params, xOffset, yOffset float64) [][]geom.Coord {
+	size := c.size(p)
+	width := strokeTextWidth(c.Text) * size
+
+	x := xOffset + p.CanvasMargin
+	switch c.Align {
+	case "center":
+		x = xOffset + (p.CanvasWidth-width)/2
+	case "right":
+		x = xOffset + p.CanvasWidth - p.CanvasMargin - width
+	}
+
+	// Inside the margin the caption is centered across it, counting the part
+	// below the baseline.
+	height := size * (1 + captionDescent)
+	var baseline float64
+	switch {
+	case c.Edge == "top" && c.Outside:
+		baseline = yOffset - c.gap(p) - size*captionDescent
+	case c.Edge == "top":
+		baseline = yOffset + (p.CanvasMargin+height)/2 - size*captionDescent
+	case c.Outside:
+		baseline = yOffset + p.CanvasHeight + c.gap(p) + size
+	default:
+		baseline = yOffset + p.CanvasHeight - (p.CanvasMargin-height)/2 - size*captionDescent
+	}
+
+	return strokeText(c.Text, geom.Coord{X: x, Y: baseline}, size)
+}
+
+// addCaptionLayer adds a layer with the captions.  Each caption is a single
+// path with a subpath per stroke.
+func (sg *SVGGrid) addCaptionLayer(r *svgdata.Root) {
+	layer := newLayer("caption", "Caption")
+	r.AddChild(layer)
+	xOffset, yOffset := sg.canvasOffset()
+	for i := range sg.Captions {
+		path := svgdata.NewPath()
+		for _, st := range sg.Captions[i].strokes(sg.p, xOffset, yOffset) {
+			cmds := []svgdata.PathCommand{}
+			for j, pt := range st {
+				pt = sg.scaleCoord(pt)
+				cmd := byte('L')
+				if j == 0 {
+					cmd = 'M'
+				}
+				cmds = append(cmds, pathCommand(cmd, pt.X, pt.Y))
+			}
+			path.SubPaths = append(path.SubPaths, svgdata.SubPath{Commands: cmds})
+		}
+		path.Attrs()["class"] = "caption"
+		layer.AddChild(path)
+	}
+}
@@ -1,8 +1,24 @@
 package main
 
-import "github.com/jbeda/geom"
+import (
+	"math"
 
+	"github.com/jbeda/geom"
+)
+
+// CircularGradient is light at its center and gets darker towards its edge.
 type CircularGradient struct {
+	// The center as a fraction of the width and height.  Nil is the middle,
+	// 0.5.
+	CenterX, CenterY *float64
+
+	// The distance at which the gradient reaches 1, as a fraction of the
+	// longer side.  Zero is the distance to the farthest corner.
+	Radius float64
+
+	// Values are raised to this power.  Zero is the same as 1, a linear fade.
+	Falloff float64
+
 	w, h    int
 	center  geom.Coord
 	maxDist float64
@@ -10,12 +26,31 @@ type CircularGradient struct {
 
 func (c *CircularGradient) SetSize(w, h int) {
 	c.w, c.h = w, h
-	c.center = geom.Coord{float64(c.w) / 2.0, float64(c.h) / 2.0}
-	c.maxDist = geom.Coord{0, 0}.DistanceFrom(c.center)
+	c.center = geom.Coord{X: fractionOr(c.CenterX, 0.5) * float64(c.w), Y: fractionOr(c.CenterY, 0.5) * float64(c.h)}
+	if c.Radius > 0 {
+		c.maxDist = c.Radius * math.Max(float64(c.w), float64(c.h))
+		return
+	}
+	c.maxDist = 0
+	for _, corner := range []geom.Coord{{X: 0, Y: 0}, {X: float64(w), Y: 0}, {X: 0, Y: float64(h)}, {X: float64(w), Y: float64(h)}} {
+		c.maxDist = math.Max(c.maxDist, corner.DistanceFrom(c.center))
+	}
+}
+
+// fractionOr returns *f, or def if f is nil.
+func fractionOr(f *float64, def float64) float64 {
+	if f == nil {
+		return def
+	}
+	return *f
 }
 
 func (c *CircularGradient) GetValue(x, y int) float64 {
-	p := geom.Coord{float64(x), float64(y)}
+	p := geom.Coord{X: float64(x), Y: float64(y)}
 	dist := p.DistanceFrom(c.center)
-	return dist / c.maxDist
+	v := clampUnit(dist / c.maxDist)
+	if c.Falloff > 0 {
+		v = math.Pow(v, c.Falloff)
+	}
+	return v
 }
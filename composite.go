@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+)
+
+// CompositeOptions combines several sources into one, for instance a photo
+// faded into a vignette or masked by a logo.
+type CompositeOptions struct {
+	// The sources to combine, by name.
+	Sources map[string]CompositeSource `json:"sources"`
+
+	// How the sources are combined.
+	Root CompositeNode `json:"root"`
+}
+
+// CompositeSource is an image, a procedural source or text.  Only one of them
+// is set.
+type CompositeSource struct {
+	// An image.  Relative paths are relative to the file the composite was
+	// loaded from.  The tone and detail settings of the job are applied to it.
+	Input string `json:"input,omitempty"`
+
+	// A procedural source.  See ParseSource.
+	Source string `json:"source,omitempty"`
+
+	// Text, which makes a good mask.  The font is found in the same way as
+	// Input.
+	Text *TextOptions `json:"text,omitempty"`
+}
+
+// CompositeNode is a node in the tree that combines sources.  In JSON it is
+// either the name of a source or an object with an op and its args.
+type CompositeNode struct {
+	// The name of a source.  If this is set nothing else is.
+	Name string `json:"-"`
+
+	// One of "multiply", "screen", "add", "min", "max", "lerp", "invert" or
+	// "remap".
+	Op string `json:"op"`
+
+	// multiply, screen, add, min, max: two or more nodes to blend.
+	// lerp: the nodes to blend from and to, then the mask that blends them.
+	// invert, remap: a single node.
+	Args []CompositeNode `json:"args"`
+
+	// remap: the values that 0 and 1 become.  The defaults are 0 and 1.
+	Low  *float64 `json:"low,omitempty"`
+	High *float64 `json:"high,omitempty"`
+}
+
+func (cn *CompositeNode) UnmarshalJSON(d []byte) error {
+	if err := json.Unmarshal(d, &cn.Name); err == nil {
+		return nil
+	}
+	// Unmarshal into a different type so that this method isn't called again.
+	type node CompositeNode
+	return json.Unmarshal(d, (*node)(cn))
+}
+
+func (cn CompositeNode) MarshalJSON() ([]byte, error) {
+	if cn.Name != "" {
+		return json.Marshal(cn.Name)
+	}
+	type node CompositeNode
+	return json.Marshal(node(cn))
+}
+
+// LoadComposite reads a JSON composition file.
+func LoadComposite(fn string) (*CompositeOptions, error) {
+	d, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	co := &CompositeOptions{}
+	if err := json.Unmarshal(d, co); err != nil {
+		return nil, fmt.Errorf("error parsing composition file %s: %v", fn, err)
+	}
+	co.resolveInputs(filepath.Dir(fn))
+	return co, nil
+}
+
+// resolveInputs makes relative inputs and fonts relative to dir.
+func (co *CompositeOptions) resolveInputs(dir string) {
+	co.mapFiles(func(fn string) string {
+		if filepath.IsAbs(fn) {
+			return fn
+		}
+		return filepath.Join(dir, fn)
+	})
+}
+
+// mapFiles replaces each of the images and fonts used by the composite with
+// the result of calling fn on it.
+func (co *CompositeOptions) mapFiles(fn func(string) string) {
+	for name, cs := range co.Sources {
+		if cs.Input != "" {
+			cs.Input = fn(cs.Input)
+		}
+		if cs.Text != nil && cs.Text.Font != "" {
+			cs.Text.Font = fn(cs.Text.Font)
+		}
+		co.Sources[name] = cs
+	}
+}
+
+// inputs returns the images and fonts used by the composite.
+func (co *CompositeOptions) inputs() []string {
+	files := []string{}
+	for _, name := range co.names() {
+		cs := co.Sources[name]
+		if cs.Input != "" {
+			files = append(files, cs.Input)
+		}
+		if cs.Text != nil && cs.Text.Font != "" {
+			files = append(files, cs.Text.Font)
+		}
+	}
+	return files
+}
+
+// names returns the names of the sources in order.
+func (co *CompositeOptions) names() []string {
+	names := []string{}
+	for name := range co.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks the sources and the tree that combines them.
+func (co *CompositeOptions) Validate() error {
+	if len(co.Sources) == 0 {
+		return fmt.Errorf("a composite needs at least one source")
+	}
+	for _, name := range co.names() {
+		cs := co.Sources[name]
+		set := 0
+		for _, ok := range []bool{cs.Input != "", cs.Source != "", cs.Text != nil} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("composite source %q needs one of an input, a source or text", name)
+		}
+		if cs.Source != "" {
+			if _, err := ParseSource(cs.Source); err != nil {
+				return fmt.Errorf("composite source %q: %v", name, err)
+			}
+		}
+		if cs.Text != nil {
+			if err := cs.Text.Validate(); err != nil {
+				return fmt.Errorf("composite source %q: %v", name, err)
+			}
+		}
+	}
+	return co.Root.validate(co)
+}
+
+func (cn *CompositeNode) validate(co *CompositeOptions) error {
+	if cn.Name != "" {
+		if _, ok := co.Sources[cn.Name]; !ok {
+			return fmt.Errorf("unknown composite source %q", cn.Name)
+		}
+		return nil
+	}
+
+	n := len(cn.Args)
+	switch cn.Op {
+	case "multiply", "screen", "add", "min", "max":
+		if n < 2 {
+			return fmt.Errorf("composite %s needs at least 2 args", cn.Op)
+		}
+	case "lerp":
+		if n != 3 {
+			return fmt.Errorf("composite lerp needs 3 args: from, to and mask")
+		}
+	case "invert", "remap":
+		if n != 1 {
+			return fmt.Errorf("composite %s needs 1 arg", cn.Op)
+		}
+	case "":
+		return fmt.Errorf("composite node needs a source name or an op")
+	default:
+		return fmt.Errorf("unknown composite op %q", cn.Op)
+	}
+	for i := range cn.Args {
+		if err := cn.Args[i].validate(co); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newCompositeContent builds the GridContent for the composite in job.  Each
+// source is only loaded once however many times it is used.
+func newCompositeContent(job *Job) (GridContent, error) {
+	co := job.Composite
+	sources := map[string]GridContent{}
+	for _, name := range co.names() {
+		cs := co.Sources[name]
+		var gc GridContent
+		var err error
+		switch {
+		case cs.Input != "":
+			gc, err = newJobContent(job, cs.Input)
+		case cs.Text != nil:
+			gc, err = NewTextContent(cs.Text, job.Params.canvasInsideWidth(), job.Params.canvasInsideHeight())
+		default:
+			gc, err = ParseSource(cs.Source)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("composite source %q: %v", name, err)
+		}
+		sources[name] = &onceContent{GridContent: gc}
+	}
+	return co.Root.build(sources), nil
+}
+
+// build returns the GridContent for the node, which has been validated.
+func (cn *CompositeNode) build(sources map[string]GridContent) GridContent {
+	if cn.Name != "" {
+		return sources[cn.Name]
+	}
+	args := []GridContent{}
+	for i := range cn.Args {
+		args = append(args, cn.Args[i].build(sources))
+	}
+	switch cn.Op {
+	case "lerp":
+		return &LerpContent{From: args[0], To: args[1], Mask: args[2]}
+	case "invert":
+		return &RemapContent{Source: args[0], Low: 1, High: 0}
+	case "remap":
+		low, high := 0.0, 1.0
+		if cn.Low != nil {
+			low = *cn.Low
+		}
+		if cn.High != nil {
+			high = *cn.High
+		}
+		return &RemapContent{Source: args[0], Low: low, High: high}
+	}
+	return &BlendContent{Mode: cn.Op, Sources: args}
+}
+
+// onceContent passes SetSize on to its content only once per size, so a
+// source that appears more than once in a composite isn't set up again.
+type onceContent struct {
+	GridContent
+	w, h int
+}
+
+func (oc *onceContent) SetSize(w, h int) {
+	if w == oc.w && h == oc.h {
+		return
+	}
+	oc.w, oc.h = w, h
+	oc.GridContent.SetSize(w, h)
+}
+
+// BlendContent combines two or more sources, in order, like the layer blend
+// modes of an image editor.  Dark is 1, so "multiply" and "min" lighten and
+// "screen", "add" and "max" darken.
+type BlendContent struct {
+	// One of "multiply", "screen", "add", "min" or "max".
+	Mode    string
+	Sources []GridContent
+}
+
+func (bc *BlendContent) SetSize(w, h int) {
+	for _, s := range bc.Sources {
+		s.SetSize(w, h)
+	}
+}
+
+func (bc *BlendContent) GetValue(x, y int) float64 {
+	v := bc.Sources[0].GetValue(x, y)
+	for _, s := range bc.Sources[1:] {
+		b := s.GetValue(x, y)
+		switch bc.Mode {
+		case "multiply":
+			v *= b
+		case "screen":
+			v = 1 - (1-v)*(1-b)
+		case "add":
+			v = clampUnit(v + b)
+		case "min":
+			v = math.Min(v, b)
+		case "max":
+			v = math.Max(v, b)
+		}
+	}
+	return v
+}
+
+// LerpContent blends from one source to another.  Where the mask is 0 the
+// value comes from From and where it is 1 it comes from To.
+type LerpContent struct {
+	From, To, Mask GridContent
+}
+
+func (lc *LerpContent) SetSize(w, h int) {
+	lc.From.SetSize(w, h)
+	lc.To.SetSize(w, h)
+	lc.Mask.SetSize(w, h)
+}
+
+func (lc *LerpContent) GetValue(x, y int) float64 {
+	t := lc.Mask.GetValue(x, y)
+	return lc.From.GetValue(x, y)*(1-t) + lc.To.GetValue(x, y)*t
+}
+
+// RemapContent stretches the values of a source so that 0 becomes Low and 1
+// becomes High.  A Low of 1 and a High of 0 inverts the source.
+type RemapContent struct {
+	Source    GridContent
+	Low, High float64
+}
+
+func (rc *RemapContent) SetSize(w, h int) {
+	rc.Source.SetSize(w, h)
+}
+
+func (rc *RemapContent) GetValue(x, y int) float64 {
+	return clampUnit(rc.Low + rc.Source.GetValue(x, y)*(rc.High-rc.Low))
+}
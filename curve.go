@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// noCircle is the radius used for a cell that should not get a circle at all.
+const noCircle = -1.0
+
+// ToneCurve maps a value from a GridContent to a circle radius.
+type ToneCurve struct {
+	// "radius" (default) grows the radius linearly with the value.  "area"
+	// grows the area of the circle linearly with the value, which keeps the
+	// overall tone closer to the source.
+	Shape string `json:"shape,omitempty"`
+
+	// Values below Skip get no circle at all.  This keeps tiny circles out of
+	// white areas.
+	Skip float64 `json:"skip,omitempty"`
+
+	// Values at or above Clamp get a circle of the maximum radius.  0 turns
+	// clamping off.
+	Clamp float64 `json:"clamp,omitempty"`
+}
+
+// Validate checks that the curve is usable.
+func (tc ToneCurve) Validate() error {
+	switch tc.Shape {
+	case "", "radius", "area":
+	default:
+		return fmt.Errorf("unknown tone curve shape: %q", tc.Shape)
+	}
+	if tc.Clamp != 0 && tc.Clamp <= tc.Skip {
+		return fmt.Errorf("tone curve clamp (%g) must be above skip (%g)", tc.Clamp, tc.Skip)
+	}
+	return nil
+}
+
+// Radius returns the radius for value v, between minRadius and maxRadius, or
+// noCircle if the cell should be skipped.
+func (tc ToneCurve) Radius(v, minRadius, maxRadius float64) float64 {
+	if v < tc.Skip {
+		return noCircle
+	}
+	if tc.Clamp != 0 && v >= tc.Clamp {
+		v = 1
+	}
+	v = clampUnit(v)
+
+	if tc.Shape == "area" {
+		return math.Sqrt(scaleToRange(v, 1.0, minRadius*minRadius, maxRadius*maxRadius))
+	}
+	return scaleToRange(v, 1.0, minRadius, maxRadius)
+}
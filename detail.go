@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// The number of pixels per grid cell (along each axis) that detail ops work at.
+// The image is reduced to this resolution before the detail ops are run so
+// that their cost and effect don't depend on the size of the source image.
+const detailOversample = 4
+
+// maxDetailRadius is the largest radius, in grid cells, a detail op can have.
+// Bigger radii make the bilateral filter very slow and use a lot of memory.
+const maxDetailRadius = 10
+
+// DetailOp is an edge or detail adjustment applied once the size of the grid is
+// known.  Radii are given in grid cells so the same settings behave the same
+// regardless of the source image resolution.
+type DetailOp struct {
+	// One of "unsharp", "edges" or "bilateral"
+	Op string `json:"op"`
+
+	// unsharp: the blur radius (default 1 cell).
+	// edges: smoothing applied before finding edges (default none).
+	// bilateral: the spatial radius (default 1 cell).
+	Radius float64 `json:"radius,omitempty"`
+
+	// unsharp: how much of the detail to add back (default 1).
+	// edges: how much to darken edges by (default 0.5).
+	Amount float64 `json:"amount,omitempty"`
+
+	// edges: "sobel" (default) or "laplacian".
+	Kernel string `json:"kernel,omitempty"`
+
+	// bilateral: how different two values can be, more than 0 and up to 1,
+	// and still be smoothed together (default 0.1).  Smaller values preserve
+	// more edges.
+	Range *float64 `json:"range,omitempty"`
+}
+
+// rangeSigma returns the bilateral range, or its default.
+func (op *DetailOp) rangeSigma() float64 {
+	if op.Range == nil {
+		return 0.1
+	}
+	return *op.Range
+}
+
+// DetailChain is an ordered list of detail adjustments.
+type DetailChain []DetailOp
+
+// Validate checks that every op in the chain is known and that its settings
+// are in range.
+func (dc DetailChain) Validate() error {
+	for _, op := range dc {
+		if op.Radius < 0 || op.Radius > maxDetailRadius {
+			return fmt.Errorf("%s radius %g is outside of the range 0 to %d cells", op.Op, op.Radius, maxDetailRadius)
+		}
+		if op.Amount < 0 {
+			return fmt.Errorf("%s amount %g is negative", op.Op, op.Amount)
+		}
+		if r := op.rangeSigma(); r <= 0 || r > 1 {
+			return fmt.Errorf("%s range %g must be more than 0 and no more than 1", op.Op, r)
+		}
+		switch op.Op {
+		case "unsharp", "bilateral":
+		case "edges":
+			if op.Kernel != "" && op.Kernel != "sobel" && op.Kernel != "laplacian" {
+				return fmt.Errorf("unknown edge kernel: %q", op.Kernel)
+			}
+		default:
+			return fmt.Errorf("unknown detail op: %q", op.Op)
+		}
+	}
+	return nil
+}
+
+// Apply reduces img to detailOversample pixels per cell for a w x h grid and
+// then runs each op in the chain in order.  It stops early with ctx's error
+// once ctx is done.
+func (dc DetailChain) Apply(ctx context.Context, img image.Image, w, h int) (image.Image, error) {
+	if len(dc) == 0 {
+		return img, nil
+	}
+
+	work := imaging.Fill(img, w*detailOversample, h*detailOversample, imaging.Center, imaging.Lanczos)
+	gp := newGrayPlane(work)
+	ppc := float64(detailOversample)
+
+	for _, op := range dc {
+		var err error
+		switch op.Op {
+		case "unsharp":
+			gp, err = gp.unsharp(ctx, defaultFloat(op.Radius, 1)*ppc, defaultFloat(op.Amount, 1))
+		case "edges":
+			gp, err = gp.edges(ctx, op.Kernel, op.Radius*ppc, defaultFloat(op.Amount, 0.5))
+		case "bilateral":
+			gp, err = gp.bilateral(ctx, defaultFloat(op.Radius, 1)*ppc, op.rangeSigma())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return gp.toImage(work), nil
+}
+
+func defaultFloat(v, def float64) float64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// grayPlane is a grayscale image with values from 0 (black) to 1 (white).
+type grayPlane struct {
+	w, h int
+	v    []float64
+}
+
+func newGrayPlane(img *image.NRGBA) *grayPlane {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	gp := &grayPlane{w: w, h: h, v: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gp.v[y*w+x] = float64(img.Pix[y*img.Stride+x*4]) / 255.0
+		}
+	}
+	return gp
+}
+
+// toImage writes the plane back out as a gray image, taking alpha from like.
+func (gp *grayPlane) toImage(like *image.NRGBA) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, gp.w, gp.h))
+	for y := 0; y < gp.h; y++ {
+		for x := 0; x < gp.w; x++ {
+			g := uint8(clampUnit(gp.v[y*gp.w+x])*255.0 + 0.5)
+			i := y*dst.Stride + x*4
+			dst.Pix[i+0] = g
+			dst.Pix[i+1] = g
+			dst.Pix[i+2] = g
+			dst.Pix[i+3] = like.Pix[y*like.Stride+x*4+3]
+		}
+	}
+	return dst
+}
+
+// eachRow calls f for each row of gp, stopping early with ctx's error once
+// ctx is done.
+func (gp *grayPlane) eachRow(ctx context.Context, f func(y int)) error {
+	for y := 0; y < gp.h; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f(y)
+	}
+	return nil
+}
+
+// at returns the value at x, y with the edges extended outward.
+func (gp *grayPlane) at(x, y int) float64 {
+	if x < 0 {
+		x = 0
+	} else if x >= gp.w {
+		x = gp.w - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= gp.h {
+		y = gp.h - 1
+	}
+	return gp.v[y*gp.w+x]
+}
+
+func (gp *grayPlane) blur(ctx context.Context, sigma float64) (*grayPlane, error) {
+	if sigma <= 0 {
+		return gp, nil
+	}
+	r := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, 2*r+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - r)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	tmp := &grayPlane{w: gp.w, h: gp.h, v: make([]float64, len(gp.v))}
+	err := gp.eachRow(ctx, func(y int) {
+		for x := 0; x < gp.w; x++ {
+			s := 0.0
+			for i, k := range kernel {
+				s += k * gp.at(x+i-r, y)
+			}
+			tmp.v[y*gp.w+x] = s
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &grayPlane{w: gp.w, h: gp.h, v: make([]float64, len(gp.v))}
+	err = gp.eachRow(ctx, func(y int) {
+		for x := 0; x < gp.w; x++ {
+			s := 0.0
+			for i, k := range kernel {
+				s += k * tmp.at(x, y+i-r)
+			}
+			out.v[y*gp.w+x] = s
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// unsharp adds back amount times the difference between the image and a
+// blurred copy of it.
+func (gp *grayPlane) unsharp(ctx context.Context, sigma, amount float64) (*grayPlane, error) {
+	blurred, err := gp.blur(ctx, sigma)
+	if err != nil {
+		return nil, err
+	}
+	out := &grayPlane{w: gp.w, h: gp.h, v: make([]float64, len(gp.v))}
+	for i, v := range gp.v {
+		out.v[i] = clampUnit(v + amount*(v-blurred.v[i]))
+	}
+	return out, nil
+}
+
+// edges darkens the image along edges so that they come out as lines of larger
+// circles.
+func (gp *grayPlane) edges(ctx context.Context, kernel string, sigma, amount float64) (*grayPlane, error) {
+	src, err := gp.blur(ctx, sigma)
+	if err != nil {
+		return nil, err
+	}
+	out := &grayPlane{w: gp.w, h: gp.h, v: make([]float64, len(gp.v))}
+	err = gp.eachRow(ctx, func(y int) {
+		for x := 0; x < gp.w; x++ {
+			var mag float64
+			if kernel == "laplacian" {
+				l := 4*src.at(x, y) - src.at(x-1, y) - src.at(x+1, y) - src.at(x, y-1) - src.at(x, y+1)
+				mag = math.Abs(l) / 4
+			} else {
+				gx, gy := src.sobel(x, y)
+				mag = math.Hypot(gx, gy) / 4
+			}
+			out.v[y*gp.w+x] = clampUnit(gp.v[y*gp.w+x] - amount*clampUnit(mag))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sobel returns the horizontal and vertical Sobel gradient at x, y.
+func (gp *grayPlane) sobel(x, y int) (float64, float64) {
+	gx := gp.at(x+1, y-1) + 2*gp.at(x+1, y) + gp.at(x+1, y+1) -
+		gp.at(x-1, y-1) - 2*gp.at(x-1, y) - gp.at(x-1, y+1)
+	gy := gp.at(x-1, y+1) + 2*gp.at(x, y+1) + gp.at(x+1, y+1) -
+		gp.at(x-1, y-1) - 2*gp.at(x, y-1) - gp.at(x+1, y-1)
+	return gx, gy
+}
+
+// bilateral smooths areas of similar value while leaving strong edges alone.
+// This flattens busy backgrounds without blurring the subject.
+func (gp *grayPlane) bilateral(ctx context.Context, sigma, rangeSigma float64) (*grayPlane, error) {
+	r := int(math.Ceil(sigma * 2))
+	spatial := make([]float64, (2*r+1)*(2*r+1))
+	for j := -r; j <= r; j++ {
+		for i := -r; i <= r; i++ {
+			spatial[(j+r)*(2*r+1)+i+r] = math.Exp(-float64(i*i+j*j) / (2 * sigma * sigma))
+		}
+	}
+
+	out := &grayPlane{w: gp.w, h: gp.h, v: make([]float64, len(gp.v))}
+	err := gp.eachRow(ctx, func(y int) {
+		for x := 0; x < gp.w; x++ {
+			c := gp.v[y*gp.w+x]
+			sum, wsum := 0.0, 0.0
+			for j := -r; j <= r; j++ {
+				for i := -r; i <= r; i++ {
+					v := gp.at(x+i, y+j)
+					d := v - c
+					wt := spatial[(j+r)*(2*r+1)+i+r] * math.Exp(-d*d/(2*rangeSigma*rangeSigma))
+					sum += wt * v
+					wsum += wt
+				}
+			}
+			out.v[y*gp.w+x] = sum / wsum
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "fmt"
+
+// EngraveOptions engraves circles as filled dots instead of cutting them out,
+// which gives a softer look.  The border is still cut.
+type EngraveOptions struct {
+	// If set, circles with at least this radius, in inches, are still cut and
+	// only the smaller ones are engraved.
+	CutMinRadius float64 `json:"cutMinRadius,omitempty"`
+}
+
+// Validate checks the engrave options.
+func (eo *EngraveOptions) Validate() error {
+	if eo.CutMinRadius < 0 {
+		return fmt.Errorf("engrave cutMinRadius must not be negative")
+	}
+	return nil
+}
+
+// engraves returns whether a circle of radius r is engraved rather than cut.
+func (eo *EngraveOptions) engraves(r float64) bool {
+	return eo != nil && (eo.CutMinRadius == 0 || r < eo.CutMinRadius)
+}
+
+// cutCircles returns the circles that are cut rather than engraved.
+func (eo *EngraveOptions) cutCircles(circles []Circle) []Circle {
+	if eo == nil {
+		return circles
+	}
+	cut := []Circle{}
+	for _, c := range circles {
+		if !eo.engraves(c.Radius) {
+			cut = append(cut, c)
+		}
+	}
+	return cut
+}
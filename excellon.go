@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/jbeda/geom"
+)
+
+const mmPerInch = 25.4
+
+// DrillOptions controls the Excellon (NC drill) output.
+type DrillOptions struct {
+	// Write coordinates and tool sizes in millimeters instead of inches.
+	Metric bool `json:"metric,omitempty"`
+}
+
+// maxDrillTools is the most tools an Excellon file can have, T01 to T99.
+const maxDrillTools = 99
+
+// drillTool is a single drill bit and the holes that it drills.
+type drillTool struct {
+	diameter float64 // in output units
+	hits     []geom.Coord
+}
+
+// WriteExcellon writes circles as an Excellon drill file.  There is one tool per
+// distinct diameter and the holes for each tool are ordered to keep travel
+// short.  Coordinates have their origin at the bottom left of the board.  A
+// summary of the tools is returned.  It is an error to need more than
+// maxDrillTools tools.
+func WriteExcellon(circles []Circle, p Params, opts DrillOptions, fn string) (string, error) {
+	scale, units, digits := 1.0, "in", 4
+	if opts.Metric {
+		scale, units, digits = mmPerInch, "mm", 3
+	}
+	round := func(f float64) float64 {
+		m := math.Pow(10, float64(digits))
+		return math.Round(f*m) / m
+	}
+
+	byDiameter := map[float64]*drillTool{}
+	for _, c := range circles {
+		d := round(2 * c.Radius * scale)
+		t, ok := byDiameter[d]
+		if !ok {
+			t = &drillTool{diameter: d}
+			byDiameter[d] = t
+		}
+		t.hits = append(t.hits, geom.Coord{X: c.Center.X * scale, Y: (p.BoardHeight - c.Center.Y) * scale})
+	}
+
+	tools := []*drillTool{}
+	for _, t := range byDiameter {
+		tools = append(tools, t)
+	}
+	if len(tools) > maxDrillTools {
+		return "", fmt.Errorf("the holes are %d different sizes but a drill file can only have %d tools; quantize the radii to use fewer sizes", len(tools), maxDrillTools)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].diameter < tools[j].diameter })
+
+	// Order the holes for each tool, carrying on from wherever the last tool
+	// finished.
+	pos := geom.Coord{}
+	travel := 0.0
+	for _, t := range tools {
+		var d float64
+		t.hits, pos, d = nearestNeighbourOrder(t.hits, pos)
+		travel += d
+	}
+
+	toolList := bytes.Buffer{}
+	for i, t := range tools {
+		toolList.WriteString(fmt.Sprintf("T%02d %.*f%s %d hits\n", i+1, digits, t.diameter, units, len(t.hits)))
+	}
+	total := fmt.Sprintf("%d tools, %d hits, %.1f%s of travel\n", len(tools), len(circles), travel, units)
+
+	b := bytes.Buffer{}
+	b.WriteString("M48\n")
+	b.WriteString("; circle-art drill file\n")
+	for _, l := range bytes.Split(bytes.TrimSpace(toolList.Bytes()), []byte("\n")) {
+		b.WriteString("; ")
+		b.Write(l)
+		b.WriteString("\n")
+	}
+	b.WriteString("; " + total)
+	if opts.Metric {
+		b.WriteString("METRIC\n")
+	} else {
+		b.WriteString("INCH\n")
+	}
+	for i, t := range tools {
+		b.WriteString(fmt.Sprintf("T%02dC%.*f\n", i+1, digits, t.diameter))
+	}
+	b.WriteString("%\n")
+	b.WriteString("G90\n")
+	b.WriteString("G05\n")
+	for i, t := range tools {
+		b.WriteString(fmt.Sprintf("T%02d\n", i+1))
+		for _, h := range t.hits {
+			b.WriteString(fmt.Sprintf("X%.*fY%.*f\n", digits, h.X, digits, h.Y))
+		}
+	}
+	b.WriteString("T00\n")
+	b.WriteString("M30\n")
+
+	// Without quantized radii there can still be a lot of tools.  Don't list
+	// them all.
+	summary := toolList.String() + total
+	if len(tools) > 20 {
+		summary = total + "WARNING: lots of tools; consider quantizing the radii\n"
+	}
+
+	return summary, ioutil.WriteFile(fn, b.Bytes(), 0644)
+}
+
+// nearestNeighbourOrder orders pts by repeatedly visiting the closest point not
+// yet visited, starting from start.  It returns the ordered points, where it
+// finished and the distance travelled.
+func nearestNeighbourOrder(pts []geom.Coord, start geom.Coord) ([]geom.Coord, geom.Coord, float64) {
+	left := append([]geom.Coord(nil), pts...)
+	ordered := make([]geom.Coord, 0, len(pts))
+	pos := start
+	travel := 0.0
+
+	for len(left) > 0 {
+		best := 0
+		bestDist := pos.DistanceFromSquared(left[0])
+		for i := 1; i < len(left); i++ {
+			if d := pos.DistanceFromSquared(left[i]); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		travel += math.Sqrt(bestDist)
+		pos = left[best]
+		ordered = append(ordered, pos)
+		left[best] = left[len(left)-1]
+		left = left[:len(left)-1]
+	}
+
+	return ordered, pos, travel
+}
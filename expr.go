@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprVars are the variables an expression can use.
+type exprVars struct {
+	x, y, r, a float64
+}
+
+// expr is a compiled expression.
+type expr func(v *exprVars) float64
+
+// exprFuncs are the functions an expression can call.  Each takes one or two
+// arguments.
+var exprFuncs = map[string]interface{}{
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"asin":  math.Asin,
+	"acos":  math.Acos,
+	"atan":  math.Atan,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"round": math.Round,
+	"atan2": math.Atan2,
+	"pow":   math.Pow,
+	"min":   math.Min,
+	"max":   math.Max,
+	"mod":   math.Mod,
+	"hypot": math.Hypot,
+}
+
+// exprConsts are the named constants an expression can use.
+var exprConsts = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// exprParser is a recursive descent parser for expressions such as
+// "sin(x*pi*4) * y^2".  The grammar, from loosest to tightest binding, is:
+//
+//	sum     = product {("+" | "-") product}
+//	product = unary {("*" | "/" | "%") unary}
+//	unary   = ("-" | "+") unary | power
+//	power   = atom ["^" unary]
+//	atom    = number | name | name "(" sum {"," sum} ")" | "(" sum ")"
+type exprParser struct {
+	s   string
+	pos int
+}
+
+// parseExpr compiles s.
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{s: s}
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:p.pos+1])
+	}
+	return e, nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("in expression %q at %d: %s", p.s, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// accept skips over c if it is next.
+func (p *exprParser) accept(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) sum() (expr, error) {
+	l, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept('+'):
+			r, err := p.product()
+			if err != nil {
+				return nil, err
+			}
+			l = func(l, r expr) expr { return func(v *exprVars) float64 { return l(v) + r(v) } }(l, r)
+		case p.accept('-'):
+			r, err := p.product()
+			if err != nil {
+				return nil, err
+			}
+			l = func(l, r expr) expr { return func(v *exprVars) float64 { return l(v) - r(v) } }(l, r)
+		default:
+			return l, nil
+		}
+	}
+}
+
+func (p *exprParser) product() (expr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept('*'):
+			r, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			l = func(l, r expr) expr { return func(v *exprVars) float64 { return l(v) * r(v) } }(l, r)
+		case p.accept('/'):
+			r, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			l = func(l, r expr) expr { return func(v *exprVars) float64 { return l(v) / r(v) } }(l, r)
+		case p.accept('%'):
+			r, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			l = func(l, r expr) expr { return func(v *exprVars) float64 { return math.Mod(l(v), r(v)) } }(l, r)
+		default:
+			return l, nil
+		}
+	}
+}
+
+func (p *exprParser) unary() (expr, error) {
+	switch {
+	case p.accept('-'):
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v *exprVars) float64 { return -e(v) }, nil
+	case p.accept('+'):
+		return p.unary()
+	}
+	return p.power()
+}
+
+func (p *exprParser) power() (expr, error) {
+	l, err := p.atom()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept('^') {
+		return l, nil
+	}
+	// Binding the exponent with unary makes 2^-x and 2^3^2 work as expected.
+	r, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return func(v *exprVars) float64 { return math.Pow(l(v), r(v)) }, nil
+}
+
+func (p *exprParser) atom() (expr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("unexpected end")
+	}
+
+	if p.accept('(') {
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, p.errorf("missing )")
+		}
+		return e, nil
+	}
+
+	start := p.pos
+	c := rune(p.s[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.s) && (unicode.IsDigit(rune(p.s[p.pos])) || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		// Allow an exponent, as in 1e-3.
+		if p.pos < len(p.s) && (p.s[p.pos] == 'e' || p.s[p.pos] == 'E') {
+			end := p.pos + 1
+			if end < len(p.s) && (p.s[end] == '-' || p.s[end] == '+') {
+				end++
+			}
+			if end < len(p.s) && unicode.IsDigit(rune(p.s[end])) {
+				p.pos = end
+				for p.pos < len(p.s) && unicode.IsDigit(rune(p.s[p.pos])) {
+					p.pos++
+				}
+			}
+		}
+		text := p.s[start:p.pos]
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("bad number %q", text)
+		}
+		return func(*exprVars) float64 { return f }, nil
+
+	case unicode.IsLetter(c):
+		for p.pos < len(p.s) && (unicode.IsLetter(rune(p.s[p.pos])) || unicode.IsDigit(rune(p.s[p.pos]))) {
+			p.pos++
+		}
+		name := strings.ToLower(p.s[start:p.pos])
+		if p.accept('(') {
+			return p.call(name, start)
+		}
+		switch name {
+		case "x":
+			return func(v *exprVars) float64 { return v.x }, nil
+		case "y":
+			return func(v *exprVars) float64 { return v.y }, nil
+		case "r":
+			return func(v *exprVars) float64 { return v.r }, nil
+		case "a":
+			return func(v *exprVars) float64 { return v.a }, nil
+		}
+		if f, ok := exprConsts[name]; ok {
+			return func(*exprVars) float64 { return f }, nil
+		}
+		p.pos = start
+		return nil, p.errorf("unknown name %q", name)
+	}
+	return nil, p.errorf("unexpected %q", p.s[p.pos:p.pos+1])
+}
+
+// call parses the arguments to the function name, which started at start.
+func (p *exprParser) call(name string, start int) (expr, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown function %q", name)
+	}
+
+	args := []expr{}
+	if !p.accept(')') {
+		for {
+			a, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if p.accept(')') {
+				break
+			}
+			if !p.accept(',') {
+				return nil, p.errorf("missing ) after arguments to %s", name)
+			}
+		}
+	}
+
+	switch fn := fn.(type) {
+	case func(float64) float64:
+		if len(args) == 1 {
+			a := args[0]
+			return func(v *exprVars) float64 { return fn(a(v)) }, nil
+		}
+		return nil, fmt.Errorf("in expression %q: %s takes 1 argument", p.s, name)
+	case func(float64, float64) float64:
+		if len(args) == 2 {
+			a, b := args[0], args[1]
+			return func(v *exprVars) float64 { return fn(a(v), b(v)) }, nil
+		}
+		return nil, fmt.Errorf("in expression %q: %s takes 2 arguments", p.s, name)
+	}
+	panic("bad expression function " + name)
+}
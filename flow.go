@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/jbeda/geom"
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// FlowOptions stretches each circle into an ellipse or a capsule whose long
+// side follows the edges of the image, so that hair and contours read more
+// clearly than they do with round dots.  Each mark keeps the area of the
+// circle it replaces, so the tone doesn't change.
+type FlowOptions struct {
+	// "ellipse" (default) or "capsule", a rectangle with round ends.
+	Shape string `json:"shape,omitempty"`
+
+	// How many times longer than it is wide a mark on a strong edge is.  The
+	// default is 3.
+	MaxAspect float64 `json:"maxAspect,omitempty"`
+
+	// How many cells around each cell are looked at to find the direction of
+	// the edges.  Bigger values give smoother flow.  The default is 1.
+	Smoothing int `json:"smoothing,omitempty"`
+}
+
+// Validate checks the flow options.
+func (fo *FlowOptions) Validate() error {
+	switch fo.Shape {
+	case "", "ellipse", "capsule":
+	default:
+		return fmt.Errorf("unknown flow shape %q", fo.Shape)
+	}
+	if fo.MaxAspect != 0 && fo.MaxAspect < 1 {
+		return fmt.Errorf("flow maxAspect must be at least 1")
+	}
+	if fo.Smoothing < 0 {
+		return fmt.Errorf("flow smoothing must not be negative")
+	}
+	return nil
+}
+
+func (fo *FlowOptions) maxAspect() float64 {
+	if fo.MaxAspect == 0 {
+		return 3
+	}
+	return fo.MaxAspect
+}
+
+func (fo *FlowOptions) smoothing() int {
+	if fo.Smoothing == 0 {
+		return 1
+	}
+	return fo.Smoothing
+}
+
+// flowMinEnergy is the smallest gradient energy, in values per cell squared,
+// that gives a direction.  Flatter areas get round marks.
+const flowMinEnergy = 1e-4
+
+// orient finds the direction of the edges at each cell of values, indexed as
+// [x][y], from the structure tensor of the values.  It returns the angle of
+// the long axis of each mark, in radians clockwise from across, and how
+// stretched the mark is, from 1 up to the max aspect.  It stops early with
+// ctx's error once ctx is done.
+func (fo *FlowOptions) orient(ctx context.Context, values [][]float64) ([][]float64, [][]float64, error) {
+	w, h := len(values), len(values[0])
+	at := func(x, y int) float64 {
+		x = int(math.Max(0, math.Min(float64(w-1), float64(x))))
+		y = int(math.Max(0, math.Min(float64(h-1), float64(y))))
+		return values[x][y]
+	}
+
+	// The products of the gradient at each cell.
+	jxx, jxy, jyy := make([][]float64, w), make([][]float64, w), make([][]float64, w)
+	for x := 0; x < w; x++ {
+		jxx[x], jxy[x], jyy[x] = make([]float64, h), make([]float64, h), make([]float64, h)
+		for y := 0; y < h; y++ {
+			gx := (at(x+1, y) - at(x-1, y)) / 2
+			gy := (at(x, y+1) - at(x, y-1)) / 2
+			jxx[x][y], jxy[x][y], jyy[x][y] = gx*gx, gx*gy, gy*gy
+		}
+	}
+
+	s := fo.smoothing()
+	angle, aspect := make([][]float64, w), make([][]float64, w)
+	for x := 0; x < w; x++ {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		angle[x], aspect[x] = make([]float64, h), make([]float64, h)
+		for y := 0; y < h; y++ {
+			var xx, xy, yy float64
+			n := 0.0
+			for i := x - s; i <= x+s; i++ {
+				for j := y - s; j <= y+s; j++ {
+					if i < 0 || i >= w || j < 0 || j >= h {
+						continue
+					}
+					xx, xy, yy = xx+jxx[i][j], xy+jxy[i][j], yy+jyy[i][j]
+					n++
+				}
+			}
+			xx, xy, yy = xx/n, xy/n, yy/n
+
+			aspect[x][y] = 1
+			energy := xx + yy
+			if energy < flowMinEnergy {
+				continue
+			}
+			// The gradient points across the edges, so the marks are turned
+			// a quarter turn from it to run along them.  How much one
+			// direction dominates (0 to 1) sets how stretched they are.
+			angle[x][y] = math.Atan2(2*xy, xx-yy)/2 + math.Pi/2
+			coherence := math.Sqrt((xx-yy)*(xx-yy)+4*xy*xy) / energy
+			aspect[x][y] = 1 + (fo.maxAspect()-1)*coherence
+		}
+	}
+	return angle, aspect, nil
+}
+
+// flowAxes returns the half length and half width of a mark with the area of
+// a circle of radius r stretched by aspect.  For a capsule the half length is
+// that of the straight part, without the round ends.
+func (fo *FlowOptions) flowAxes(r, aspect float64) (float64, float64) {
+	if fo.Shape == "capsule" {
+		b := r * math.Sqrt(math.Pi/(math.Pi+4*(aspect-1)))
+		return (aspect - 1) * b, b
+	}
+	return r * math.Sqrt(aspect), r / math.Sqrt(aspect)
+}
+
+// perimeter returns the length around the mark for c, in inches.  Ellipses
+// use Ramanujan's approximation.
+func (fo *FlowOptions) perimeter(c Circle) float64 {
+	a, b := fo.flowAxes(c.Radius, math.Max(1, c.Aspect))
+	if fo.Shape == "capsule" {
+		return 4*a + 2*math.Pi*b
+	}
+	return math.Pi * (3*(a+b) - math.Sqrt((3*a+b)*(a+3*b)))
+}
+
+// fit returns the most stretched a mark for a circle of radius r at angle can
+// be, up to aspect, and still not reach further from its center, across or
+// down, than a circle of maxRadius.  That keeps at least the margin between
+// neighbouring cells.  Marks are made rounder rather than smaller so that they
+// keep their area.
+func (fo *FlowOptions) fit(r, angle, aspect, maxRadius float64) float64 {
+	sin, cos := math.Abs(math.Sin(angle)), math.Abs(math.Cos(angle))
+	fits := func(k float64) bool {
+		a, b := fo.flowAxes(r, k)
+		var ex, ey float64
+		if fo.Shape == "capsule" {
+			ex, ey = a*cos+b, a*sin+b
+		} else {
+			ex = math.Sqrt(a*a*cos*cos + b*b*sin*sin)
+			ey = math.Sqrt(a*a*sin*sin + b*b*cos*cos)
+		}
+		return math.Max(ex, ey) <= maxRadius+1e-9
+	}
+	if fits(aspect) {
+		return aspect
+	}
+	lo, hi := 1.0, aspect
+	for i := 0; i < 30; i++ {
+		mid := (lo + hi) / 2
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// flowPath returns the ellipse or capsule for c as a path.
+func (sg *SVGGrid) flowPath(c Circle) *svgdata.Path {
+	a, b := sg.Flow.flowAxes(sg.scaleValue(c.Radius), math.Max(1, c.Aspect))
+	center := sg.scaleCoord(c.Center)
+	along := geom.Coord{X: math.Cos(c.Angle), Y: math.Sin(c.Angle)}
+	across := geom.Coord{X: -along.Y, Y: along.X}
+
+	// A capsule with no straight part is written as a circle.
+	capsule := sg.Flow.Shape == "capsule" && a > 0
+	if !capsule && sg.Flow.Shape == "capsule" {
+		a = b
+	}
+
+	var cmds []svgdata.PathCommand
+	if capsule {
+		// Two straight sides joined by half circles.
+		p := []geom.Coord{
+			center.Plus(along.Times(a)).Plus(across.Times(b)),
+			center.Minus(along.Times(a)).Plus(across.Times(b)),
+			center.Minus(along.Times(a)).Minus(across.Times(b)),
+			center.Plus(along.Times(a)).Minus(across.Times(b)),
+		}
+		cmds = []svgdata.PathCommand{
+			pathCommand('M', p[0].X, p[0].Y),
+			pathCommand('L', p[1].X, p[1].Y),
+			pathCommand('A', b, b, 0, 0, 1, p[2].X, p[2].Y),
+			pathCommand('L', p[3].X, p[3].Y),
+			pathCommand('A', b, b, 0, 0, 1, p[0].X, p[0].Y),
+		}
+	} else {
+		// Two half ellipses.
+		p0, p1 := center.Plus(along.Times(a)), center.Minus(along.Times(a))
+		deg := c.Angle * 180 / math.Pi
+		cmds = []svgdata.PathCommand{
+			pathCommand('M', p0.X, p0.Y),
+			pathCommand('A', a, b, deg, 0, 1, p1.X, p1.Y),
+			pathCommand('A', a, b, deg, 0, 1, p0.X, p0.Y),
+		}
+	}
+	cmds = append(cmds, svgdata.PathCommand{Command: 'Z'})
+	path := svgdata.NewPath()
+	path.SubPaths = []svgdata.SubPath{{Commands: cmds}}
+	return path
+}
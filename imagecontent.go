@@ -1,26 +1,50 @@
 package main
 
 import (
+	"context"
 	"image"
 
-	"image/color"
-
 	"github.com/disintegration/imaging"
 )
 
 type ImageContent struct {
-	w, h       int
-	src, small image.Image
+	w, h  int
+	src   image.Image
+	small *image.NRGBA
+
+	// Detail adjustments applied once the grid size is known.
+	Detail DetailChain
 }
 
-func NewImageContent(fn string) (*ImageContent, error) {
+var _ CoverageContent = (*ImageContent)(nil)
+var _ contextSizer = (*ImageContent)(nil)
+
+func NewImageContent(fn string, tone ToneChain) (*ImageContent, error) {
 	src, err := imaging.Open(fn)
 	if err != nil {
 		return nil, err
 	}
+	return NewImageContentFromImage(src, tone)
+}
 
+// NewImageContentFromImage is NewImageContent for an image that has already
+// been loaded.
+func NewImageContentFromImage(src image.Image, tone ToneChain) (*ImageContent, error) {
+	return NewImageContentContext(context.Background(), src, tone)
+}
+
+// NewImageContentContext is NewImageContentFromImage but stops early, returning
+// ctx's error, once ctx is done.
+func NewImageContentContext(ctx context.Context, src image.Image, tone ToneChain) (*ImageContent, error) {
+	// Grayscale keeps the alpha channel so transparent areas can be left
+	// empty.
 	src = imaging.Grayscale(src)
 
+	src, err := tone.ApplyContext(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
 	if src.Bounds().Dx() < src.Bounds().Dy() {
 		src = imaging.Rotate90(src)
 	}
@@ -29,11 +53,25 @@ func NewImageContent(fn string) (*ImageContent, error) {
 }
 
 func (ic *ImageContent) SetSize(w, h int) {
+	ic.SetSizeContext(context.Background(), w, h)
+}
+
+func (ic *ImageContent) SetSizeContext(ctx context.Context, w, h int) error {
 	ic.w, ic.h = w, h
-	ic.small = imaging.Invert(imaging.Fill(ic.src, ic.w, ic.h, imaging.Center, imaging.Lanczos))
+	src, err := ic.Detail.Apply(ctx, ic.src, ic.w, ic.h)
+	if err != nil {
+		return err
+	}
+	ic.small = imaging.Invert(imaging.Fill(src, ic.w, ic.h, imaging.Center, imaging.Lanczos))
+	return nil
 }
 
 func (ic *ImageContent) GetValue(x, y int) float64 {
-	c := color.GrayModel.Convert(ic.small.At(x, y)).(color.Gray)
-	return float64(c.Y) / 255.0
+	// Read the gray value straight from the pixel so that it isn't
+	// premultiplied by alpha.
+	return float64(ic.small.Pix[ic.small.PixOffset(x, y)]) / 255.0
+}
+
+func (ic *ImageContent) GetCoverage(x, y int) float64 {
+	return float64(ic.small.Pix[ic.small.PixOffset(x, y)+3]) / 255.0
 }
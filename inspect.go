@@ -0,0 +1,682 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jbeda/geom"
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// foundCircle is a circle read back from an SVG.  Positions are in inches.
+type foundCircle struct {
+	center geom.Coord
+	radius float64
+	group  string
+	class  string
+	color  string
+}
+
+// foundRect is a rect read back from an SVG.  Positions are in inches.
+type foundRect struct {
+	r     geom.Rect
+	class string
+}
+
+// svgScan walks an SVG and collects the circles and rects in it.
+type svgScan struct {
+	scale   float64 // inches per user unit
+	classes map[string]map[string]string
+	circles []foundCircle
+	rects   []foundRect
+	groups  []string
+
+	// Shapes that will be cut but aren't circles, such as flow marks, shapes
+	// and motifs, by element name.  They aren't checked.
+	others map[string]int
+}
+
+// otherShapes are the elements, besides circles and rects, that draw a line
+// a cutter would follow.
+var otherShapes = map[string]bool{
+	"path": true, "ellipse": true, "line": true, "polyline": true, "polygon": true, "use": true,
+}
+
+var cssRuleRE = regexp.MustCompile(`\.([\w-]+)\s*\{([^}]*)\}`)
+
+// parseStyle parses the properties of a style attribute or CSS rule.
+func parseStyle(s string) map[string]string {
+	props := map[string]string{}
+	for _, decl := range strings.Split(s, ";") {
+		kv := strings.SplitN(decl, ":", 2)
+		if len(kv) == 2 {
+			props[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return props
+}
+
+// color returns the color an element is drawn in.  The stroke is used if there
+// is one, otherwise the fill.
+func (s *svgScan) color(am svgdata.AttrMap) string {
+	props := map[string]string{}
+	for _, c := range strings.Fields(am["class"]) {
+		for k, v := range s.classes[c] {
+			props[k] = v
+		}
+	}
+	for _, k := range []string{"fill", "stroke"} {
+		if v, ok := am[k]; ok {
+			props[k] = v
+		}
+	}
+	for k, v := range parseStyle(am["style"]) {
+		props[k] = v
+	}
+
+	if v := props["stroke"]; v != "" && v != "none" {
+		return v
+	}
+	if v := props["fill"]; v != "" && v != "none" {
+		return v
+	}
+	return "none"
+}
+
+func (s *svgScan) walk(n svgdata.Node, m affine, group string) {
+	am := n.Attrs()
+	if t, ok := am["transform"]; ok {
+		m = m.times(parseTransform(t))
+	}
+
+	// Only what is <use>d from these is drawn.
+	if n.Name() == "defs" || n.Name() == "symbol" {
+		for _, c := range *n.Children() {
+			if c.Name() == "style" {
+				s.walk(c, m, group)
+			}
+		}
+		return
+	}
+
+	counted := false
+	switch nn := n.(type) {
+	case *svgdata.Circle:
+		s.circles = append(s.circles, foundCircle{
+			center: m.apply(nn.Center).Times(s.scale),
+			radius: nn.Radius * m.scale() * s.scale,
+			group:  group,
+			class:  am["class"],
+			color:  s.color(am),
+		})
+	case *svgdata.Path:
+		if am["class"] == "border" {
+			// A border that isn't a plain rect, such as a notched tile or an
+			// ellipse.
+			r := geom.NilRect()
+			for _, c := range pathPoints(nn.SubPaths) {
+				r.ExpandToContainCoord(m.apply(c).Times(s.scale))
+			}
+			s.rects = append(s.rects, foundRect{r: r, class: am["class"]})
+			counted = true
+		} else if center, radius, ok := pathCircle(nn.SubPaths); ok {
+			s.circles = append(s.circles, foundCircle{
+				center: m.apply(center).Times(s.scale),
+				radius: radius * m.scale() * s.scale,
+				group:  group,
+				class:  am["class"],
+				color:  s.color(am),
+			})
+			counted = true
+		}
+	case *svgdata.Rect:
+		r := geom.NilRect()
+		for _, c := range []geom.Coord{nn.R.Min, nn.R.Max, {X: nn.R.Min.X, Y: nn.R.Max.Y}, {X: nn.R.Max.X, Y: nn.R.Min.Y}} {
+			r.ExpandToContainCoord(m.apply(c).Times(s.scale))
+		}
+		s.rects = append(s.rects, foundRect{r: r, class: am["class"]})
+	}
+	// Captions are engraved text, not cut.
+	if !counted && otherShapes[n.Name()] && am["class"] != "caption" {
+		s.others[n.Name()]++
+	}
+
+	if n.Name() == "style" {
+		for _, rule := range cssRuleRE.FindAllStringSubmatch(n.GetText(), -1) {
+			s.classes[rule[1]] = parseStyle(rule[2])
+		}
+	}
+
+	if n.Name() == "g" {
+		switch {
+		case am["label"] != "":
+			group = am["label"]
+		case am["id"] != "":
+			group = am["id"]
+		default:
+			group = fmt.Sprintf("group %d", len(s.groups)+1)
+		}
+		s.groups = append(s.groups, group)
+	}
+
+	for _, c := range *n.Children() {
+		s.walk(c, m, group)
+	}
+}
+
+// pathCircle works out whether a path traces a circle, either with arcs or with
+// cubic Béziers, and if so returns its center and radius.
+func pathCircle(sps []svgdata.SubPath) (geom.Coord, float64, bool) {
+	var centers, ends []geom.Coord
+	var radius float64
+	for _, sp := range sps {
+		var cur, start geom.Coord
+		for _, c := range sp.Commands {
+			var rel geom.Coord
+			if c.Command >= 'a' {
+				rel = cur
+			}
+			switch c.Command {
+			case 'M', 'm':
+				cur = rel.Plus(geom.Coord{X: c.Params[0], Y: c.Params[1]})
+				start = cur
+			case 'Z', 'z':
+				cur = start
+			case 'A', 'a':
+				rx, ry := c.Params[0], c.Params[1]
+				if math.Abs(rx-ry) > rx*0.01 {
+					return geom.Coord{}, 0, false
+				}
+				end := rel.Plus(geom.Coord{X: c.Params[5], Y: c.Params[6]})
+				centers = append(centers, arcCenter(cur, end, rx, c.Params[3] != 0, c.Params[4] != 0))
+				radius = math.Max(rx, cur.DistanceFrom(end)/2)
+				cur = end
+			case 'C', 'c':
+				cur = rel.Plus(geom.Coord{X: c.Params[4], Y: c.Params[5]})
+				ends = append(ends, cur)
+			default:
+				return geom.Coord{}, 0, false
+			}
+		}
+	}
+
+	switch {
+	case len(centers) > 0 && len(ends) == 0:
+		for _, c := range centers[1:] {
+			if c.DistanceFrom(centers[0]) > radius*0.01 {
+				return geom.Coord{}, 0, false
+			}
+		}
+		return centers[0], radius, true
+	case len(ends) >= 3 && len(centers) == 0:
+		center, ok := circumcenter(ends[0], ends[len(ends)/3], ends[2*len(ends)/3])
+		if !ok {
+			return geom.Coord{}, 0, false
+		}
+		radius = center.DistanceFrom(ends[0])
+		for _, e := range ends[1:] {
+			if math.Abs(center.DistanceFrom(e)-radius) > radius*0.01 {
+				return geom.Coord{}, 0, false
+			}
+		}
+		return center, radius, true
+	}
+	return geom.Coord{}, 0, false
+}
+
+func anyContains(rects []geom.Rect, r geom.Rect) bool {
+	for _, o := range rects {
+		if o.ContainsRect(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathPoints returns the point that each command in a path ends on.
+func pathPoints(sps []svgdata.SubPath) []geom.Coord {
+	pts := []geom.Coord{}
+	for _, sp := range sps {
+		var cur, start geom.Coord
+		for _, c := range sp.Commands {
+			var rel geom.Coord
+			if c.Command >= 'a' {
+				rel = cur
+			}
+			switch c.Command {
+			case 'Z', 'z':
+				cur = start
+			case 'H':
+				cur.X = c.Params[0]
+			case 'h':
+				cur.X += c.Params[0]
+			case 'V':
+				cur.Y = c.Params[0]
+			case 'v':
+				cur.Y += c.Params[0]
+			default:
+				n := len(c.Params)
+				cur = rel.Plus(geom.Coord{X: c.Params[n-2], Y: c.Params[n-1]})
+				if c.Command == 'M' || c.Command == 'm' {
+					start = cur
+				}
+			}
+			pts = append(pts, cur)
+		}
+	}
+	return pts
+}
+
+// circumcenter returns the center of the circle through a, b and c.  ok is
+// false if they are in a line.
+func circumcenter(a, b, c geom.Coord) (center geom.Coord, ok bool) {
+	d := 2 * (a.X*(b.Y-c.Y) + b.X*(c.Y-a.Y) + c.X*(a.Y-b.Y))
+	if d == 0 {
+		return geom.Coord{}, false
+	}
+	a2, b2, c2 := a.X*a.X+a.Y*a.Y, b.X*b.X+b.Y*b.Y, c.X*c.X+c.Y*c.Y
+	return geom.Coord{
+		X: (a2*(b.Y-c.Y) + b2*(c.Y-a.Y) + c2*(a.Y-b.Y)) / d,
+		Y: (a2*(c.X-b.X) + b2*(a.X-c.X) + c2*(b.X-a.X)) / d,
+	}, true
+}
+
+// arcCenter returns the center of a circular SVG arc of radius r from p1 to p2.
+// large and sweep are the arc's flags.
+func arcCenter(p1, p2 geom.Coord, r float64, large, sweep bool) geom.Coord {
+	mid := p1.Plus(p2).Times(0.5)
+	half := p1.DistanceFrom(p2) / 2
+	// Half circles are very sensitive to rounding in the path data so treat
+	// anything close as exactly half.
+	if half == 0 || half > r*0.999 {
+		return mid
+	}
+	k := math.Sqrt(math.Max(0, r*r-half*half)) / half
+	if large == sweep {
+		k = -k
+	}
+	return mid.Plus(geom.Coord{X: (p1.Y - p2.Y) / 2, Y: (p2.X - p1.X) / 2}.Times(k))
+}
+
+// svgSize returns the size of an SVG in inches and the number of inches per
+// user unit.
+func svgSize(r *svgdata.Root) (float64, float64, float64) {
+	am := r.Attrs()
+	var vb []float64
+	for _, f := range strings.FieldsFunc(am["viewBox"], func(r rune) bool { return r == ' ' || r == ',' }) {
+		v, err := strconv.ParseFloat(f, 64)
+		if err == nil {
+			vb = append(vb, v)
+		}
+	}
+
+	w, wok := parseLength(am["width"])
+	h, hok := parseLength(am["height"])
+	scale := 1.0 / 96.0
+	if len(vb) == 4 && vb[2] > 0 {
+		if wok {
+			scale = w / vb[2]
+		}
+		if !wok {
+			w = vb[2] * scale
+		}
+		if !hok {
+			h = vb[3] * scale
+		}
+	}
+	return w, h, scale
+}
+
+var lengthRE = regexp.MustCompile(`^\s*([-+]?[0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)\s*(in|mm|cm|pt|pc|px)?\s*$`)
+
+// parseLength parses an SVG length into inches.
+func parseLength(s string) (float64, bool) {
+	caps := lengthRE.FindStringSubmatch(s)
+	if caps == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(caps[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	switch caps[2] {
+	case "in":
+		return v, true
+	case "mm":
+		return v / mmPerInch, true
+	case "cm":
+		return v * 10 / mmPerInch, true
+	case "pt":
+		return v / 72, true
+	case "pc":
+		return v / 6, true
+	default:
+		return v / 96, true
+	}
+}
+
+// affine is a 2D affine transform in SVG's matrix(a b c d e f) form.
+type affine [6]float64
+
+var identity = affine{1, 0, 0, 1, 0, 0}
+
+func (m affine) times(n affine) affine {
+	return affine{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m affine) apply(c geom.Coord) geom.Coord {
+	return geom.Coord{X: m[0]*c.X + m[2]*c.Y + m[4], Y: m[1]*c.X + m[3]*c.Y + m[5]}
+}
+
+// scale is how much the transform scales lengths by, on average.
+func (m affine) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+var transformRE = regexp.MustCompile(`(matrix|translate|scale|rotate|skewX|skewY)\s*\(([^)]*)\)`)
+
+// parseTransform parses an SVG transform attribute.  Unknown parts are
+// ignored.
+func parseTransform(s string) affine {
+	m := identity
+	for _, t := range transformRE.FindAllStringSubmatch(s, -1) {
+		var args []float64
+		for _, f := range strings.FieldsFunc(t[2], func(r rune) bool { return r == ' ' || r == ',' }) {
+			v, err := strconv.ParseFloat(f, 64)
+			if err == nil {
+				args = append(args, v)
+			}
+		}
+		arg := func(i int, def float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return def
+		}
+
+		n := identity
+		switch t[1] {
+		case "matrix":
+			if len(args) == 6 {
+				copy(n[:], args)
+			}
+		case "translate":
+			n[4], n[5] = arg(0, 0), arg(1, 0)
+		case "scale":
+			n[0] = arg(0, 1)
+			n[3] = arg(1, n[0])
+		case "rotate":
+			a := arg(0, 0) * math.Pi / 180
+			cx, cy := arg(1, 0), arg(2, 0)
+			n = affine{1, 0, 0, 1, cx, cy}.
+				times(affine{math.Cos(a), math.Sin(a), -math.Sin(a), math.Cos(a), 0, 0}).
+				times(affine{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			n[2] = math.Tan(arg(0, 0) * math.Pi / 180)
+		case "skewY":
+			n[1] = math.Tan(arg(0, 0) * math.Pi / 180)
+		}
+		m = m.times(n)
+	}
+	return m
+}
+
+// circlePair is two circles that are too close to each other.
+type circlePair struct {
+	a, b foundCircle
+	web  float64
+}
+
+// findCloseCircles returns the smallest distance between two circle centers,
+// the pair with the smallest web (the material left between them) and every
+// pair with a web below minWeb.
+func findCloseCircles(circles []foundCircle, minWeb float64) (float64, *circlePair, []circlePair) {
+	sorted := append([]foundCircle(nil), circles...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].center.X < sorted[j].center.X })
+
+	maxR := 0.0
+	for _, c := range sorted {
+		maxR = math.Max(maxR, c.radius)
+	}
+
+	minDist, minWebFound := math.Inf(1), math.Inf(1)
+	var minPair *circlePair
+	var close []circlePair
+	for i, a := range sorted {
+		for j := i + 1; j < len(sorted); j++ {
+			b := sorted[j]
+			// Circles further along can't be any closer than dx, and their
+			// web can't be less than dx less two of the biggest radius.
+			dx := b.center.X - a.center.X
+			if dx >= minDist && dx-2*maxR >= minWebFound && dx-2*maxR >= minWeb {
+				break
+			}
+			d := a.center.DistanceFrom(b.center)
+			minDist = math.Min(minDist, d)
+			web := d - a.radius - b.radius
+			if web < minWebFound {
+				minWebFound = web
+				minPair = &circlePair{a, b, web}
+			}
+			if web < minWeb {
+				close = append(close, circlePair{a, b, web})
+			}
+		}
+	}
+	return minDist, minPair, close
+}
+
+// inspectMain implements the inspect command.  It reports on the circles in
+// any SVG to catch mistakes, especially after hand editing.
+func inspectMain(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	minWeb := fs.Float64("min-web", 0, "also report circles closer than this to each other (inches)")
+	boardWidth := fs.Float64("board-width", 0, "board width to check against (inches, default from the SVG)")
+	boardHeight := fs.Float64("board-height", 0, "board height to check against (inches, default from the SVG)")
+	bins := fs.Int("bins", 10, "number of bins in the radius histogram")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "USAGE: circle-art inspect [flags] <svg-file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	fn := fs.Arg(0)
+
+	r, err := ReadSVG(fn)
+	check(err)
+
+	w, h, scale := svgSize(r)
+	if *boardWidth != 0 {
+		w = *boardWidth
+	}
+	if *boardHeight != 0 {
+		h = *boardHeight
+	}
+
+	s := &svgScan{scale: scale, classes: map[string]map[string]string{}, others: map[string]int{}}
+	// Style elements apply to the whole document so find them first.
+	for _, n := range *r.Children() {
+		if n.Name() == "style" {
+			s.walk(n, identity, "")
+		}
+	}
+	s.walk(r, identity, "(top level)")
+
+	fmt.Printf("%s: %.4gin x %.4gin, %d circles\n", fn, w, h, len(s.circles))
+	unchecked := 0
+	if len(s.others) > 0 {
+		names := []string{}
+		for name, n := range s.others {
+			names = append(names, fmt.Sprintf("%d %s", n, name))
+			unchecked += n
+		}
+		sort.Strings(names)
+		fmt.Printf("Warning: %d shapes that aren't circles weren't checked (%s)\n", unchecked, strings.Join(names, ", "))
+	}
+	if len(s.circles) == 0 {
+		return
+	}
+
+	// Per group counts, in document order.
+	fmt.Println("\nGroups:")
+	counts := map[string]int{}
+	colors := map[string]map[string]bool{}
+	order := []string{}
+	for _, c := range s.circles {
+		if _, ok := counts[c.group]; !ok {
+			order = append(order, c.group)
+			colors[c.group] = map[string]bool{}
+		}
+		counts[c.group]++
+		colors[c.group][strings.TrimSpace(c.class+" "+c.color)] = true
+	}
+	for _, g := range order {
+		cs := []string{}
+		for c := range colors[g] {
+			cs = append(cs, c)
+		}
+		sort.Strings(cs)
+		fmt.Printf("  %-20s %6d  %s\n", g, counts[g], strings.Join(cs, ", "))
+	}
+
+	// Radius histogram
+	minR, maxR := math.Inf(1), 0.0
+	bounds := geom.NilRect()
+	for _, c := range s.circles {
+		minR = math.Min(minR, c.radius)
+		maxR = math.Max(maxR, c.radius)
+		bounds.ExpandToContainCoord(c.center.Minus(geom.Coord{X: c.radius, Y: c.radius}))
+		bounds.ExpandToContainCoord(c.center.Plus(geom.Coord{X: c.radius, Y: c.radius}))
+	}
+	if *bins < 1 {
+		*bins = 1
+	}
+	hist := make([]int, *bins)
+	binWidth := (maxR - minR) / float64(*bins)
+	for _, c := range s.circles {
+		i := *bins - 1
+		if binWidth > 0 {
+			i = int((c.radius - minR) / binWidth)
+		}
+		if i >= *bins {
+			i = *bins - 1
+		}
+		hist[i]++
+	}
+	most := 0
+	for _, n := range hist {
+		if n > most {
+			most = n
+		}
+	}
+	fmt.Println("\nRadius (in):")
+	for i, n := range hist {
+		lo := minR + float64(i)*binWidth
+		fmt.Printf("  %.4f-%.4f %6d %s\n", lo, lo+binWidth, n, strings.Repeat("#", n*40/most))
+	}
+
+	// Spacing
+	minDist, minPair, close := findCloseCircles(s.circles, *minWeb)
+	fmt.Println()
+	if minPair != nil {
+		fmt.Printf("Min center distance: %.4fin\n", minDist)
+		fmt.Printf("Min web: %.4fin between (%.3f, %.3f) and (%.3f, %.3f)\n", minPair.web,
+			minPair.a.center.X, minPair.a.center.Y, minPair.b.center.X, minPair.b.center.Y)
+	}
+	fmt.Printf("Bounds: (%.3f, %.3f) to (%.3f, %.3f)\n", bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y)
+
+	board := geom.Rect{Max: geom.Coord{X: w, Y: h}}
+	// Boards of nested pieces have a border for each piece.  Without any
+	// marked borders the largest rect is taken to be the border.
+	var borders []geom.Rect
+	var largest *foundRect
+	for i, rr := range s.rects {
+		if rr.class == "border" {
+			borders = append(borders, rr.r)
+		}
+		if largest == nil || rr.r.Width()*rr.r.Height() > largest.r.Width()*largest.r.Height() {
+			largest = &s.rects[i]
+		}
+	}
+	if borders == nil && largest != nil {
+		borders = append(borders, largest.r)
+	}
+
+	if len(borders) == 1 {
+		fmt.Printf("Border: (%.3f, %.3f) to (%.3f, %.3f)\n", borders[0].Min.X, borders[0].Min.Y, borders[0].Max.X, borders[0].Max.Y)
+	} else if len(borders) > 1 {
+		fmt.Printf("Borders: %d\n", len(borders))
+	}
+
+	// Problems
+	problems := 0
+	report := func(what string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		problems += len(items)
+		fmt.Printf("\n%d %s:\n", len(items), what)
+		for i, it := range items {
+			if i == 10 {
+				fmt.Printf("  ... and %d more\n", len(items)-i)
+				break
+			}
+			fmt.Printf("  %s\n", it)
+		}
+	}
+	describe := func(c foundCircle) string {
+		return fmt.Sprintf("(%.3f, %.3f) r=%.4f in %s", c.center.X, c.center.Y, c.radius, c.group)
+	}
+
+	var overlaps, thin []string
+	for _, p := range close {
+		line := fmt.Sprintf("%s and %s, web %.4f", describe(p.a), describe(p.b), p.web)
+		if p.web < 0 {
+			overlaps = append(overlaps, line)
+		} else {
+			thin = append(thin, line)
+		}
+	}
+	report("overlapping pairs", overlaps)
+	report(fmt.Sprintf("pairs with less than %gin between them", *minWeb), thin)
+
+	var outsideBorder, outsideBoard []string
+	for _, c := range s.circles {
+		cb := geom.Rect{
+			Min: c.center.Minus(geom.Coord{X: c.radius, Y: c.radius}),
+			Max: c.center.Plus(geom.Coord{X: c.radius, Y: c.radius}),
+		}
+		if w > 0 && h > 0 && !board.ContainsRect(cb) {
+			outsideBoard = append(outsideBoard, describe(c))
+		} else if len(borders) > 0 && !anyContains(borders, cb) {
+			outsideBorder = append(outsideBorder, describe(c))
+		}
+	}
+	report("circles outside the board", outsideBoard)
+	report("circles outside the border", outsideBorder)
+
+	if problems != 0 {
+		os.Exit(1)
+	}
+	if unchecked != 0 {
+		fmt.Printf("\nNo problems found in the circles, but %d other shapes weren't checked\n", unchecked)
+		return
+	}
+	fmt.Println("\nNo problems found")
+}
@@ -1,8 +1,25 @@
 package main
 
+import "context"
+
 type GridContent interface {
 	SetSize(w, h int)
 
 	// The GridContent should return a value between 0 and 1 for this "pixel"
 	GetValue(x, y int) float64
 }
+
+// contextSizer is a GridContent that can take a while to size, and can stop
+// early, returning ctx's error, once ctx is done.
+type contextSizer interface {
+	SetSizeContext(ctx context.Context, w, h int) error
+}
+
+// CoverageContent is a GridContent that knows how much of each "pixel" is
+// covered by the subject, for instance from the alpha channel of an image.
+type CoverageContent interface {
+	GridContent
+
+	// GetCoverage returns a value between 0 (empty) and 1 (fully covered)
+	GetCoverage(x, y int) float64
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+)
+
+// Job describes a single rendering run.  It can be loaded from a JSON job file
+// so that the settings for a piece can be kept alongside the source image.
+type Job struct {
+	// The image to render.  Relative paths are relative to the job file.
+	Input string `json:"input,omitempty"`
+
+	// A procedural source, such as "noise:seed=3" or "expr:x*y", to render in
+	// place of an image.  See ParseSource.
+	Source string `json:"source,omitempty"`
+
+	// If set, several images and sources are combined and rendered in place
+	// of Input.
+	Composite *CompositeOptions `json:"composite,omitempty"`
+
+	// If set, text is rendered in place of Input.
+	Text *TextOptions `json:"text,omitempty"`
+
+	// The dimensions of the piece.
+	Params Params `json:"params"`
+
+	// Tone adjustments applied to the image before it is sampled.
+	Tone ToneChain `json:"tone,omitempty"`
+
+	// Detail adjustments applied once the image has been reduced to a few
+	// pixels per grid cell.
+	Detail DetailChain `json:"detail,omitempty"`
+
+	// How values are mapped to circle radii.
+	Curve ToneCurve `json:"curve,omitempty"`
+
+	// How transparent areas of the input are handled.
+	Alpha *AlphaOptions `json:"alpha,omitempty"`
+
+	// If set, circle radii are limited to a fixed set of sizes.
+	Quantize *Quantizer `json:"quantize,omitempty"`
+
+	// The units ("in", the default, or "mm") for the size of the SVG.
+	Units string `json:"units,omitempty"`
+
+	// If set, circles are written as <path> elements instead of <circle>
+	// elements.
+	Paths *PathOptions `json:"paths,omitempty"`
+
+	// If set, cells are cut as squares, hexagons, stars and so on instead of
+	// circles.
+	Shape *ShapeOptions `json:"shape,omitempty"`
+
+	// If set, cells are cut as a drawing from an SVG file instead of circles.
+	Motif *MotifOptions `json:"motif,omitempty"`
+
+	// If set, circles are stretched into ellipses or capsules that follow the
+	// edges of the image.
+	Flow *FlowOptions `json:"flow,omitempty"`
+
+	// If set, larger circles are left attached to the board by small tabs.
+	Tabs *TabOptions `json:"tabs,omitempty"`
+
+	// If set, some or all of the circles are engraved instead of cut.
+	Engrave *EngraveOptions `json:"engrave,omitempty"`
+
+	// If set, a stacked relief is written as one SVG per sheet.
+	Layers *LayerOptions `json:"layers,omitempty"`
+
+	// If set, a canvas bigger than the board is split into tiles with one SVG
+	// per tile.
+	Tiles *TileOptions `json:"tiles,omitempty"`
+
+	// If set, several pieces, each with their own input, are laid out together
+	// on the board and Input isn't used.
+	Nest *NestOptions `json:"nest,omitempty"`
+
+	// Text, such as a title or a signature, engraved in the margin of the
+	// canvas or beside it.
+	Captions []Caption `json:"captions,omitempty"`
+
+	// If set, an Excellon drill file is written alongside the SVG.
+	Drill *DrillOptions `json:"drill,omitempty"`
+}
+
+// NewJob returns a Job with the default settings.
+func NewJob() *Job {
+	return &Job{Params: DefaultParams()}
+}
+
+// LoadJob reads a JSON job file.  Anything not in the file keeps its default.
+func LoadJob(fn string) (*Job, error) {
+	d, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	j := NewJob()
+	if err := json.Unmarshal(d, j); err != nil {
+		return nil, fmt.Errorf("error parsing job file %s: %v", fn, err)
+	}
+
+	if j.Input != "" && !filepath.IsAbs(j.Input) {
+		j.Input = filepath.Join(filepath.Dir(fn), j.Input)
+	}
+	if j.Nest != nil {
+		for i, pc := range j.Nest.Pieces {
+			if pc.Input != "" && !filepath.IsAbs(pc.Input) {
+				j.Nest.Pieces[i].Input = filepath.Join(filepath.Dir(fn), pc.Input)
+			}
+		}
+	}
+	if j.Composite != nil {
+		j.Composite.resolveInputs(filepath.Dir(fn))
+	}
+	if j.Text != nil && j.Text.Font != "" && !filepath.IsAbs(j.Text.Font) {
+		j.Text.Font = filepath.Join(filepath.Dir(fn), j.Text.Font)
+	}
+	if j.Motif != nil && j.Motif.File != "" && !filepath.IsAbs(j.Motif.File) {
+		j.Motif.File = filepath.Join(filepath.Dir(fn), j.Motif.File)
+	}
+
+	return j, nil
+}
+
+// Validate checks the settings in the job.
+func (j *Job) Validate() error {
+	// When tiling, the canvas only has to fit on the board one tile at a time.
+	p := j.Params
+	if j.Tiles != nil {
+		p.BoardWidth = math.Max(p.BoardWidth, p.CanvasWidth)
+		p.BoardHeight = math.Max(p.BoardHeight, p.CanvasHeight)
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if j.Source != "" {
+		if j.Input != "" || j.Nest != nil {
+			return fmt.Errorf("a job can have an input image or a source but not both")
+		}
+		if _, err := ParseSource(j.Source); err != nil {
+			return err
+		}
+	}
+	if j.Composite != nil {
+		if j.Input != "" || j.Source != "" || j.Nest != nil {
+			return fmt.Errorf("a composite can't be combined with an input image, a source or nested pieces")
+		}
+		if err := j.Composite.Validate(); err != nil {
+			return err
+		}
+	}
+	if j.Text != nil {
+		if j.Input != "" || j.Source != "" || j.Composite != nil || j.Nest != nil {
+			return fmt.Errorf("text can't be combined with an input image, a source, a composite or nested pieces")
+		}
+		if err := j.Text.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := j.Detail.Validate(); err != nil {
+		return err
+	}
+	if j.Alpha != nil {
+		if err := j.Alpha.Validate(); err != nil {
+			return err
+		}
+	}
+	if j.Units != "" && j.Units != "in" && j.Units != "mm" {
+		return fmt.Errorf("unknown units %q", j.Units)
+	}
+	if err := j.Curve.Validate(); err != nil {
+		return err
+	}
+	if j.Quantize != nil {
+		if err := j.Quantize.Validate(j.Params.MaxRadius()); err != nil {
+			return err
+		}
+	}
+	if j.Paths != nil {
+		if err := j.Paths.Validate(); err != nil {
+			return err
+		}
+	}
+	if j.Shape != nil {
+		if j.Paths != nil || j.Tabs != nil || j.Drill != nil {
+			return fmt.Errorf("shapes can't be written as arcs, given tabs or drilled")
+		}
+		if err := j.Shape.Validate(); err != nil {
+			return err
+		}
+	}
+	if j.Motif != nil {
+		if j.Shape != nil || j.Paths != nil || j.Tabs != nil || j.Drill != nil {
+			return fmt.Errorf("a motif can't be combined with shapes, written as arcs, given tabs or drilled")
+		}
+		if err := j.Motif.Validate(); err != nil {
+			return err
+		}
+	}
+	if j.Flow != nil {
+		if j.Shape != nil || j.Motif != nil || j.Paths != nil || j.Tabs != nil || j.Drill != nil {
+			return fmt.Errorf("flow can't be combined with shapes or motifs, written as arcs, given tabs or drilled")
+		}
+		if err := j.Flow.Validate(); err != nil {
+			return err
+		}
+	}
+	if j.Tabs != nil {
+		if err := j.Tabs.Validate(j.Params.MaxRadius()); err != nil {
+			return err
+		}
+	}
+	if j.Engrave != nil {
+		if err := j.Engrave.Validate(); err != nil {
+			return err
+		}
+	}
+	if j.Layers != nil {
+		if err := j.Layers.Validate(j.Params); err != nil {
+			return err
+		}
+	}
+	if j.Nest != nil {
+		if j.Input != "" {
+			return fmt.Errorf("a nest job takes its inputs from its pieces")
+		}
+		if j.Layers != nil || j.Tiles != nil {
+			return fmt.Errorf("nested pieces can't also be layered or tiled")
+		}
+		if err := j.Nest.Validate(j.Params); err != nil {
+			return err
+		}
+	}
+	if j.Tiles != nil {
+		if j.Layers != nil {
+			return fmt.Errorf("a stacked relief can't also be tiled")
+		}
+		if err := j.Tiles.Validate(j.Params); err != nil {
+			return err
+		}
+	}
+	if len(j.Captions) > 0 && (j.Layers != nil || j.Tiles != nil || j.Nest != nil) {
+		return fmt.Errorf("captions can't be added to layered, tiled or nested jobs")
+	}
+	for i := range j.Captions {
+		if err := j.Captions[i].Validate(j.Params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/jbeda/geom"
+)
+
+// LayerOptions renders a stacked relief: a set of sheets that are glued one on
+// top of another.  Each deeper sheet only has holes where the image is darker
+// so the stack shows the tone as depth.
+type LayerOptions struct {
+	// The number of sheets with holes.  The default is 3.
+	Count int `json:"count,omitempty"`
+
+	// Add a solid sheet, with only the dowel holes, at the bottom of the stack.
+	Backing bool `json:"backing,omitempty"`
+
+	// The radius, in inches, of the dowel holes in the corners of each sheet.
+	// The default is a quarter of the canvas margin.
+	DowelRadius float64 `json:"dowelRadius,omitempty"`
+}
+
+// Validate checks that the layer options work with p.
+func (lo *LayerOptions) Validate(p Params) error {
+	if lo.Count < 0 {
+		return fmt.Errorf("layer count must not be negative")
+	}
+	if r := lo.dowelRadius(p); r <= 0 || r >= p.CanvasMargin/2 {
+		return fmt.Errorf("dowel radius %g must be more than 0 and less than half the canvas margin (%g)", r, p.CanvasMargin/2)
+	}
+	return nil
+}
+
+func (lo *LayerOptions) count() int {
+	if lo.Count == 0 {
+		return 3
+	}
+	return lo.Count
+}
+
+func (lo *LayerOptions) dowelRadius(p Params) float64 {
+	if lo.DowelRadius == 0 {
+		return p.CanvasMargin / 4
+	}
+	return lo.DowelRadius
+}
+
+// sheets returns the total number of sheets, including any backing sheet.
+func (lo *LayerOptions) sheets() int {
+	if lo.Backing {
+		return lo.count() + 1
+	}
+	return lo.count()
+}
+
+// sheetCircles returns the circles cut in sheet, counting from 0 at the top.
+// A cell has a hole in a sheet if its value is above the level for that sheet.
+// The holes are the same size in every sheet they go through so that the holes
+// in a deeper sheet always sit inside the holes above it.
+func (lo *LayerOptions) sheetCircles(circles []Circle, sheet int) []Circle {
+	level := float64(sheet) / float64(lo.count())
+	sc := []Circle{}
+	if sheet >= lo.count() {
+		return sc
+	}
+	for _, c := range circles {
+		if c.Value > level {
+			sc = append(sc, c)
+		}
+	}
+	return sc
+}
+
+// dowels returns the alignment holes, one in each corner of the canvas margin.
+// They are in the same place on every sheet.
+func (lo *LayerOptions) dowels(p Params, xOffset, yOffset float64) []Circle {
+	in := p.CanvasMargin / 2
+	r := lo.dowelRadius(p)
+	return []Circle{
+		{Center: geom.Coord{X: xOffset + in, Y: yOffset + in}, Radius: r},
+		{Center: geom.Coord{X: xOffset + p.CanvasWidth - in, Y: yOffset + in}, Radius: r},
+		{Center: geom.Coord{X: xOffset + p.CanvasWidth - in, Y: yOffset + p.CanvasHeight - in}, Radius: r},
+		{Center: geom.Coord{X: xOffset + in, Y: yOffset + p.CanvasHeight - in}, Radius: r},
+	}
+}
+
+// sheetInfo is the extra information written on a single sheet of a stacked
+// relief.
+type sheetInfo struct {
+	number, count int
+	label         string
+	dowels        []Circle
+}
+
+// renderLayers lays out gc and writes one SVG per sheet of a stacked relief.
+// The circles for all of the sheets are returned.
+func renderLayers(job *Job, sg *SVGGrid, gc GridContent, outputPrefix string) ([]Circle, error) {
+	circles := sg.Layout(gc)
+	all := []Circle{}
+	xOffset, yOffset := sg.canvasOffset()
+	for i := 0; i < job.Layers.sheets(); i++ {
+		sheetPrefix := fmt.Sprintf("%s-sheet%d", outputPrefix, i+1)
+		sg.sheet = &sheetInfo{
+			number: i + 1,
+			count:  job.Layers.sheets(),
+			label:  fmt.Sprintf("%s %d/%d", filepath.Base(outputPrefix), i+1, job.Layers.sheets()),
+			dowels: job.Layers.dowels(job.Params, xOffset, yOffset),
+		}
+		sc := job.Layers.sheetCircles(circles, i)
+		sg.WriteSVG(sc, sheetPrefix)
+		fmt.Printf("%s: %d circles\n", sheetPrefix, len(sc))
+		if err := writeOutputs(job, sc, sheetPrefix); err != nil {
+			return nil, err
+		}
+		all = append(all, sc...)
+	}
+	return all, nil
+}
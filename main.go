@@ -1,27 +1,376 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+func usage() {
+	fmt.Fprintln(os.Stderr, "USAGE: circle-art [flags] <jpg-file>")
+	fmt.Fprintln(os.Stderr, "       circle-art [flags] -source <source>")
+	fmt.Fprintln(os.Stderr, "       circle-art [flags] -composite <json-file>")
+	fmt.Fprintln(os.Stderr, "       circle-art [flags] -text <text> -font <ttf-file>")
+	fmt.Fprintln(os.Stderr, "       circle-art rerender [flags] <svg-file>")
+	fmt.Fprintln(os.Stderr, "       circle-art inspect [flags] <svg-file>")
+	fmt.Fprintln(os.Stderr, "       circle-art serve [flags]")
+	flag.PrintDefaults()
+}
+
+// check exits with a message if err is set.
+func check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "circle-art: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// jobFlags are the command line flags that can override settings in a job.
+type jobFlags struct {
+	drill       *bool
+	units       *string
+	paths       *string
+	randomStart *bool
+	tabs        *int
+	shape       *string
+	rotation    *float64
+	motif       *string
+	inline      *bool
+	flow        *string
+	engrave     *bool
+	cutMin      *float64
+	layers      *int
+	tiles       *bool
+	source      *string
+	text        *string
+	font        *string
+	textSize    *float64
+	textAlign   *string
+	textVAlign  *string
+	caption     *string
+	captionSize *float64
+	captionAt   *string
+	params      *ParamFlags
+}
+
+func addJobFlags(fs *flag.FlagSet) *jobFlags {
+	return &jobFlags{
+		drill:       fs.Bool("drill", false, "also write an Excellon drill file"),
+		units:       fs.String("units", "", "units for the size of the SVG: in or mm"),
+		paths:       fs.String("paths", "", "write circles as paths made of arcs or beziers"),
+		randomStart: fs.Bool("random-start", false, "start each circle path at a random angle"),
+		tabs:        fs.Int("tabs", 0, "leave this many uncut tabs on each circle"),
+		shape:       fs.String("shape", "", "cut cells as a `shape` instead of circles: square, diamond, hexagon, triangle, star or plus"),
+		rotation:    fs.Float64("shape-rotation", 0, "turn each -shape by this many degrees clockwise"),
+		motif:       fs.String("motif", "", "cut cells as the drawing in this SVG `file` instead of circles"),
+		inline:      fs.Bool("motif-inline", false, "write the -motif out for every cell instead of using <symbol> and <use>"),
+		flow:        fs.String("flow", "", "stretch circles along the edges of the image into `shape`s: ellipse or capsule"),
+		engrave:     fs.Bool("engrave", false, "engrave circles as filled dots instead of cutting them"),
+		cutMin:      fs.Float64("cut-min-radius", 0, "when engraving, still cut circles with at least this radius"),
+		layers:      fs.Int("layers", 0, "write a stacked relief with this many sheets"),
+		tiles:       fs.Bool("tiles", false, "split a canvas bigger than the board into tiles"),
+		source:      fs.String("source", "", "render a procedural `source` instead of an image: linear, radial, noise, sine, checker or expr:<f(x,y)>"),
+		text:        fs.String("text", "", "render this `text` instead of an image; \\n starts a new line"),
+		font:        fs.String("font", "", "TrueType or OpenType font `file` for -text"),
+		textSize:    fs.Float64("text-size", 0, "font size for -text in inches (default as big as fits)"),
+		textAlign:   fs.String("text-align", "", "left, center or right"),
+		textVAlign:  fs.String("text-valign", "", "top, middle or bottom"),
+		caption:     fs.String("caption", "", "engrave this `text` in the margin below the canvas; {date} is replaced with today's date"),
+		captionSize: fs.Float64("caption-size", 0, "height of capitals in the -caption in inches (default half the canvas margin)"),
+		captionAt:   fs.String("caption-align", "", "left, center or right"),
+		params:      AddParamFlags(fs),
+	}
+}
+
+// apply overrides the settings in job with any flags that were given.
+func (jf *jobFlags) apply(job *Job) {
+	if *jf.drill && job.Drill == nil {
+		job.Drill = &DrillOptions{}
+	}
+	if *jf.units != "" {
+		job.Units = *jf.units
+	}
+	if (*jf.paths != "" || *jf.randomStart) && job.Paths == nil {
+		job.Paths = &PathOptions{}
+	}
+	if *jf.paths != "" {
+		job.Paths.Curves = *jf.paths
+	}
+	if *jf.randomStart {
+		job.Paths.Random = true
+	}
+	if *jf.tabs > 0 {
+		if job.Tabs == nil {
+			job.Tabs = &TabOptions{}
+		}
+		job.Tabs.Count = *jf.tabs
+	}
+	if *jf.shape != "" {
+		if job.Shape == nil {
+			job.Shape = &ShapeOptions{}
+		}
+		job.Shape.Kind = *jf.shape
+	}
+	if *jf.rotation != 0 && job.Shape != nil {
+		job.Shape.Rotation = *jf.rotation
+	}
+	if *jf.motif != "" {
+		if job.Motif == nil {
+			job.Motif = &MotifOptions{}
+		}
+		job.Motif.File = *jf.motif
+	}
+	if *jf.inline && job.Motif != nil {
+		job.Motif.Inline = true
+	}
+	if *jf.flow != "" {
+		if job.Flow == nil {
+			job.Flow = &FlowOptions{}
+		}
+		job.Flow.Shape = *jf.flow
+	}
+	if (*jf.engrave || *jf.cutMin > 0) && job.Engrave == nil {
+		job.Engrave = &EngraveOptions{}
+	}
+	if *jf.cutMin > 0 {
+		job.Engrave.CutMinRadius = *jf.cutMin
+	}
+	if *jf.layers > 0 {
+		if job.Layers == nil {
+			job.Layers = &LayerOptions{}
+		}
+		job.Layers.Count = *jf.layers
+	}
+	if *jf.tiles && job.Tiles == nil {
+		job.Tiles = &TileOptions{}
+	}
+	if *jf.source != "" {
+		job.Source = *jf.source
+		job.Input = ""
+	}
+	if *jf.text != "" {
+		job.Text = &TextOptions{Text: strings.Replace(*jf.text, `\n`, "\n", -1)}
+		job.Input = ""
+	}
+	if job.Text != nil {
+		if *jf.font != "" {
+			job.Text.Font = *jf.font
+		}
+		if *jf.textSize > 0 {
+			job.Text.Size = *jf.textSize
+		}
+		if *jf.textAlign != "" {
+			job.Text.Align = *jf.textAlign
+		}
+		if *jf.textVAlign != "" {
+			job.Text.VAlign = *jf.textVAlign
+		}
+	}
+	if *jf.caption != "" {
+		job.Captions = append(job.Captions, Caption{Text: *jf.caption, Size: *jf.captionSize, Align: *jf.captionAt})
+	}
+	jf.params.Apply(&job.Params)
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintln(os.Stderr, "USAGE: circle-art <jpg-file>")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rerender":
+			rerenderMain(os.Args[2:])
+			return
+		case "inspect":
+			inspectMain(os.Args[2:])
+			return
+		case "serve":
+			serveMain(os.Args[2:])
+			return
+		}
+	}
+
+	jobFile := flag.String("job", "", "JSON job file with settings for this render")
+	watch := flag.Bool("watch", false, "render again whenever the input or job file changes")
+	compositeFile := flag.String("composite", "", "JSON composition `file` that combines several images and sources")
+	jf := addJobFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() > 1 {
+		usage()
 		os.Exit(1)
 	}
 
-	input := os.Args[1]
+	// The job is loaded by a function so that watch mode can load it again
+	// when the job file changes.
+	load := func() (*Job, string, error) {
+		job := NewJob()
+		if *jobFile != "" {
+			var err error
+			job, err = LoadJob(*jobFile)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		if flag.NArg() == 1 {
+			if *jf.source != "" || *compositeFile != "" || *jf.text != "" {
+				return nil, "", fmt.Errorf("give one of an input image, -source, -composite or -text")
+			}
+			job.Input = flag.Arg(0)
+		}
+		if *compositeFile != "" {
+			co, err := LoadComposite(*compositeFile)
+			if err != nil {
+				return nil, "", err
+			}
+			job.Input, job.Source, job.Composite = "", "", co
+		}
+		jf.apply(job)
+		if job.Input == "" && job.Source == "" && job.Nest == nil && job.Composite == nil && job.Text == nil {
+			return nil, "", fmt.Errorf("no input image given")
+		}
 
-	sg := NewSVGGrid()
-	//sg.RenderGrid(&CircularGradient{})
-	ic, err := NewImageContent(input)
+		// A nest job has an input per piece so it is named after the job file,
+		// and a composite is named after the file it came from.  A source is
+		// named after its kind, and text "text", unless there is a job file.
+		name := job.Input
+		switch {
+		case *compositeFile != "":
+			name = *compositeFile
+		case job.Input == "" && *jobFile != "":
+			name = *jobFile
+		case job.Source != "":
+			name = strings.SplitN(job.Source, ":", 2)[0]
+		case job.Text != nil:
+			name = "text"
+		}
+		outputPrefix := filepath.Base(name)
+		outputPrefix = strings.TrimSuffix(outputPrefix, filepath.Ext(outputPrefix))
+		return job, outputPrefix, nil
+	}
+
+	if flag.NArg() == 0 && *jobFile == "" && *jf.source == "" && *compositeFile == "" && *jf.text == "" {
+		usage()
+		os.Exit(1)
+	}
+	if *watch {
+		watchJob(load, *jobFile, *compositeFile)
+		return
+	}
+
+	job, outputPrefix, err := load()
+	check(err)
+	_, err = renderJob(job, outputPrefix)
+	check(err)
+}
+
+// renderJob renders job to <outputPrefix>.svg along with any other outputs the
+// job asks for.  Every circle that was written out is returned.
+func renderJob(job *Job, outputPrefix string) ([]Circle, error) {
+	fillCaptionDates(job, time.Now())
+	if err := job.Validate(); err != nil {
+		return nil, err
+	}
+
+	if job.Nest != nil {
+		return renderNest(job, outputPrefix)
+	}
+
+	// A tiled canvas is laid out as a whole, as if there were a board big
+	// enough for it, before it is split up.
+	p := job.Params
+	if job.Tiles != nil {
+		p.BoardWidth, p.BoardHeight = p.CanvasWidth, p.CanvasHeight
+	}
+	sg, err := newJobGrid(job, p)
+	if err != nil {
+		return nil, err
+	}
+	var gc GridContent
+	switch {
+	case job.Composite != nil:
+		gc, err = newCompositeContent(job)
+	case job.Text != nil:
+		gc, err = NewTextContent(job.Text, p.canvasInsideWidth(), p.canvasInsideHeight())
+	case job.Source != "":
+		gc, err = ParseSource(job.Source)
+	default:
+		gc, err = newJobContent(job, job.Input)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sg.Meta, err = NewMetadata(job)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	switch {
+	case job.Layers != nil:
+		return renderLayers(job, sg, gc, outputPrefix)
+	case job.Tiles != nil:
+		return renderTiles(job, sg, gc, outputPrefix)
 	}
-	outputPrefix := filepath.Base(input)
-	outputPrefix = strings.TrimSuffix(outputPrefix, filepath.Ext(outputPrefix))
-	sg.RenderGrid(ic, outputPrefix)
+
+	circles := sg.RenderGrid(gc, outputPrefix)
+	fmt.Printf("%d circles, %d skipped\n", len(circles), sg.Cells()-len(circles))
+	return circles, writeOutputs(job, circles, outputPrefix)
+}
+
+// newJobGrid returns a grid for params p with the rest of the settings from
+// job.  The motif, if there is one, is loaded.
+func newJobGrid(job *Job, p Params) (*SVGGrid, error) {
+	sg := NewSVGGrid(p)
+	sg.Curve = job.Curve
+	sg.Alpha = job.Alpha
+	sg.Quantizer = job.Quantize
+	sg.Units = job.Units
+	sg.Paths = job.Paths
+	sg.Shape = job.Shape
+	sg.Tabs = job.Tabs
+	sg.Engrave = job.Engrave
+	sg.Flow = job.Flow
+	sg.Captions = job.Captions
+	if job.Motif != nil {
+		var err error
+		sg.Motif, err = LoadMotif(job.Motif)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sg, nil
+}
+
+// newJobContent loads the image input with the tone and detail settings of
+// job.
+func newJobContent(job *Job, input string) (*ImageContent, error) {
+	ic, err := NewImageContent(input, job.Tone)
+	if err != nil {
+		return nil, err
+	}
+	ic.Detail = job.Detail
+	return ic, nil
+}
+
+// writeOutputs writes any outputs the job asks for besides the SVG for
+// circles, which has already been written to <outputPrefix>.svg.
+func writeOutputs(job *Job, circles []Circle, outputPrefix string) error {
+	cut := job.Engrave.cutCircles(circles)
+	if job.Engrave != nil {
+		fmt.Printf("%d engraved, %d cut\n", len(circles)-len(cut), len(cut))
+	}
+	if job.Tabs != nil {
+		if n := job.Tabs.untabbed(cut); n > 0 {
+			fmt.Printf("%d circles are too small for tabs and are cut without them\n", n)
+		}
+	}
+
+	if job.Drill != nil {
+		summary, err := WriteExcellon(cut, job.Params, *job.Drill, fmt.Sprintf("%s.drl", outputPrefix))
+		if err != nil {
+			return err
+		}
+		fmt.Print(summary)
+	}
+
+	return nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+const inkscapeNs = "http://www.inkscape.org/namespaces/inkscape"
+
+// Metadata records how an SVG was made.  It is stored as JSON in the SVG's
+// <metadata> element.
+type Metadata struct {
+	Generator string `json:"generator"`
+	Job       *Job   `json:"job,omitempty"`
+
+	// The SHA-256 of the input file, hex encoded.
+	InputSHA256 string `json:"inputSHA256,omitempty"`
+
+	// For nested pieces, the SHA-256 of each piece's input, in the same order
+	// as the pieces.
+	PieceSHA256 []string `json:"pieceSHA256,omitempty"`
+}
+
+// NewMetadata returns the metadata for rendering job.  The input file, or the
+// input of each nested piece, is hashed so that it can be matched up later.
+func NewMetadata(job *Job) (*Metadata, error) {
+	m := &Metadata{Generator: "circle-art", Job: job}
+	if job.Input != "" {
+		h, err := fileSHA256(job.Input)
+		if err != nil {
+			return nil, err
+		}
+		m.InputSHA256 = h
+	}
+	if job.Nest != nil {
+		for _, pc := range job.Nest.Pieces {
+			h, err := fileSHA256(pc.Input)
+			if err != nil {
+				return nil, err
+			}
+			m.PieceSHA256 = append(m.PieceSHA256, h)
+		}
+	}
+	return m, nil
+}
+
+func (m *Metadata) String() string {
+	d, _ := json.MarshalIndent(m, "", "  ")
+	return string(d)
+}
+
+func fileSHA256(fn string) (string, error) {
+	d, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(d)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ReadMetadata reads the Metadata that circle-art stored in an SVG file.
+func ReadMetadata(fn string) (*Metadata, error) {
+	r, err := ReadSVG(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range *r.Children() {
+		if n.Name() != "metadata" {
+			continue
+		}
+		m := &Metadata{Job: NewJob()}
+		if err := json.Unmarshal([]byte(n.GetText()), m); err != nil {
+			continue
+		}
+		if m.Generator == "circle-art" {
+			return m, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s has no circle-art metadata", fn)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jbeda/geom"
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// MotifOptions cuts each cell as a drawing from an SVG file, such as a heart
+// or a logo, instead of a circle.
+type MotifOptions struct {
+	// The SVG file.  Relative paths are relative to the job file.
+	File string `json:"file"`
+
+	// If set, the motif is written out in full for every cell.  Otherwise it
+	// is written once as a <symbol> that each cell <use>s, which keeps files
+	// small but isn't understood by some cutter software.
+	Inline bool `json:"inline,omitempty"`
+}
+
+// Validate checks the motif options.  The file is only read when rendering.
+func (mo *MotifOptions) Validate() error {
+	if mo.File == "" {
+		return fmt.Errorf("a motif needs an SVG file")
+	}
+	return nil
+}
+
+// motifStroke is one line of a motif.
+type motifStroke struct {
+	pts    []geom.Coord
+	closed bool
+}
+
+// Motif is a drawing loaded from an SVG file, flattened into straight lines
+// and centered on (0, 0).  Its longer side goes from -1 to 1.
+type Motif struct {
+	strokes []motifStroke
+	inline  bool
+}
+
+// motifCurveSteps is the number of straight lines each curve of a motif is
+// split into.
+const motifCurveSteps = 16
+
+// motifSkip are elements whose contents aren't drawn directly.
+var motifSkip = map[string]bool{
+	"defs": true, "symbol": true, "clipPath": true, "mask": true, "marker": true, "pattern": true,
+}
+
+// LoadMotif reads the motif in mo.File.
+func LoadMotif(mo *MotifOptions) (*Motif, error) {
+	r, err := ReadSVG(mo.File)
+	if err != nil {
+		return nil, fmt.Errorf("error reading motif %s: %v", mo.File, err)
+	}
+	m := &Motif{inline: mo.Inline}
+	for _, n := range *r.Children() {
+		if err := m.walk(n, identity); err != nil {
+			return nil, fmt.Errorf("error reading motif %s: %v", mo.File, err)
+		}
+	}
+
+	bounds := geom.NilRect()
+	for _, st := range m.strokes {
+		for _, p := range st.pts {
+			bounds.ExpandToContainCoord(p)
+		}
+	}
+	size := math.Max(bounds.Width(), bounds.Height())
+	if len(m.strokes) == 0 || size == 0 {
+		return nil, fmt.Errorf("motif %s has nothing to draw", mo.File)
+	}
+	center := bounds.Center()
+	for _, st := range m.strokes {
+		for i, p := range st.pts {
+			st.pts[i] = p.Minus(center).Times(2 / size)
+		}
+	}
+	return m, nil
+}
+
+// length returns the total length of the motif's lines at its unit size.
+func (m *Motif) length() float64 {
+	l := 0.0
+	for _, st := range m.strokes {
+		l += polylineLength(st.pts, st.closed)
+	}
+	return l
+}
+
+// walk adds the lines drawn by n and its children, transformed by t.
+func (m *Motif) walk(n svgdata.Node, t affine) error {
+	am := n.Attrs()
+	if motifSkip[n.Name()] || am["display"] == "none" {
+		return nil
+	}
+	if tr, ok := am["transform"]; ok {
+		t = t.times(parseTransform(tr))
+	}
+
+	switch nn := n.(type) {
+	case *svgdata.Path:
+		m.addPath(nn.SubPaths, t)
+	case *svgdata.Circle:
+		m.addEllipse(nn.Center, nn.Radius, nn.Radius, t)
+	case *svgdata.Rect:
+		r := nn.R
+		m.add(t, true, r.Min, geom.Coord{X: r.Max.X, Y: r.Min.Y}, r.Max, geom.Coord{X: r.Min.X, Y: r.Max.Y})
+	case *svgdata.Polyshape:
+		// svgdata reads polylines as polygons, so they are closed too.
+		vals, err := motifNumbers(strings.Replace(am["points"], ",", " ", -1))
+		if err != nil {
+			return err
+		}
+		pts := []geom.Coord{}
+		for i := 0; i+1 < len(vals); i += 2 {
+			pts = append(pts, geom.Coord{X: vals[i], Y: vals[i+1]})
+		}
+		m.add(t, true, pts...)
+	}
+
+	switch n.Name() {
+	case "ellipse", "line":
+		keys := []string{"cx", "cy", "rx", "ry"}
+		if n.Name() == "line" {
+			keys = []string{"x1", "y1", "x2", "y2"}
+		}
+		v := make([]float64, len(keys))
+		for i, k := range keys {
+			if s, ok := am[k]; ok {
+				f, err := strconv.ParseFloat(strings.TrimSuffix(s, "px"), 64)
+				if err != nil {
+					return fmt.Errorf("bad %s %s %q", n.Name(), k, s)
+				}
+				v[i] = f
+			}
+		}
+		if n.Name() == "line" {
+			m.add(t, false, geom.Coord{X: v[0], Y: v[1]}, geom.Coord{X: v[2], Y: v[3]})
+		} else {
+			m.addEllipse(geom.Coord{X: v[0], Y: v[1]}, v[2], v[3], t)
+		}
+	}
+
+	for _, c := range *n.Children() {
+		if err := m.walk(c, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func motifNumbers(s string) ([]float64, error) {
+	vals := []float64{}
+	for _, f := range strings.Fields(s) {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", f)
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// add adds a stroke through pts, transformed by t.
+func (m *Motif) add(t affine, closed bool, pts ...geom.Coord) {
+	if len(pts) < 2 {
+		return
+	}
+	st := motifStroke{closed: closed}
+	for _, p := range pts {
+		st.pts = append(st.pts, t.apply(p))
+	}
+	m.strokes = append(m.strokes, st)
+}
+
+func (m *Motif) addEllipse(center geom.Coord, rx, ry float64, t affine) {
+	pts := []geom.Coord{}
+	for i := 0; i < 4*motifCurveSteps; i++ {
+		a := 2 * math.Pi * float64(i) / (4 * motifCurveSteps)
+		pts = append(pts, geom.Coord{X: center.X + rx*math.Cos(a), Y: center.Y + ry*math.Sin(a)})
+	}
+	m.add(t, true, pts...)
+}
+
+// addPath adds the subpaths of a path, with curves split into straight lines.
+func (m *Motif) addPath(sps []svgdata.SubPath, t affine) {
+	var cur, start, ctrl geom.Coord
+	var pts []geom.Coord
+	var last byte
+	flush := func(closed bool) {
+		m.add(t, closed, pts...)
+		pts = nil
+	}
+	cubic := func(c1, c2, end geom.Coord) {
+		p0 := cur
+		for i := 1; i <= motifCurveSteps; i++ {
+			s := float64(i) / motifCurveSteps
+			u := 1 - s
+			pts = append(pts, p0.Times(u*u*u).Plus(c1.Times(3*u*u*s)).Plus(c2.Times(3*u*s*s)).Plus(end.Times(s*s*s)))
+		}
+	}
+
+	for _, sp := range sps {
+		for _, c := range sp.Commands {
+			var rel geom.Coord
+			if c.Command >= 'a' {
+				rel = cur
+			}
+			at := func(i int) geom.Coord {
+				return rel.Plus(geom.Coord{X: c.Params[i], Y: c.Params[i+1]})
+			}
+			// The first control point of a smooth curve is the last one
+			// reflected, if the last command was the same kind of curve.
+			reflected := func(kinds string) geom.Coord {
+				if strings.IndexByte(kinds, last) >= 0 {
+					return cur.Times(2).Minus(ctrl)
+				}
+				return cur
+			}
+
+			cmd := c.Command &^ 0x20
+			switch cmd {
+			case 'M':
+				flush(false)
+				cur = at(0)
+				start = cur
+				pts = []geom.Coord{cur}
+			case 'Z':
+				if len(pts) > 1 {
+					flush(true)
+				}
+				cur = start
+				pts = []geom.Coord{cur}
+			case 'L', 'T':
+				end := at(0)
+				if cmd == 'T' {
+					ctrl = reflected("QT")
+					cubic(cur.Plus(ctrl.Minus(cur).Times(2.0/3)), end.Plus(ctrl.Minus(end).Times(2.0/3)), end)
+				} else {
+					pts = append(pts, end)
+				}
+				cur = end
+			case 'H', 'V':
+				if cmd == 'H' {
+					cur.X = rel.X + c.Params[0]
+				} else {
+					cur.Y = rel.Y + c.Params[0]
+				}
+				pts = append(pts, cur)
+			case 'C', 'S':
+				c1 := reflected("CS")
+				if cmd == 'C' {
+					c1 = at(0)
+				}
+				n := len(c.Params)
+				ctrl = at(n - 4)
+				end := at(n - 2)
+				cubic(c1, ctrl, end)
+				cur = end
+			case 'Q':
+				ctrl = at(0)
+				end := at(2)
+				cubic(cur.Plus(ctrl.Minus(cur).Times(2.0/3)), end.Plus(ctrl.Minus(end).Times(2.0/3)), end)
+				cur = end
+			case 'A':
+				end := at(5)
+				pts = append(pts, arcPoints(cur, end, c.Params[0], c.Params[1], c.Params[2], c.Params[3] != 0, c.Params[4] != 0)...)
+				cur = end
+			}
+			last = cmd
+		}
+	}
+	flush(false)
+}
+
+// arcPoints returns points along an SVG elliptical arc from p1 to p2, not
+// including p1.  This follows the conversion in the SVG spec's implementation
+// notes.
+func arcPoints(p1, p2 geom.Coord, rx, ry, rotation float64, large, sweep bool) []geom.Coord {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p1 == p2 {
+		return []geom.Coord{p2}
+	}
+	phi := rotation * math.Pi / 180
+	sin, cos := math.Sin(phi), math.Cos(phi)
+
+	dx, dy := (p1.X-p2.X)/2, (p1.Y-p2.Y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+
+	// Radii that are too small are scaled up until the arc fits.
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	k := math.Sqrt(math.Max(0, num/(rx*rx*y1*y1+ry*ry*x1*x1)))
+	if large == sweep {
+		k = -k
+	}
+	cx1, cy1 := k*rx*y1/ry, -k*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (p1.X+p2.X)/2
+	cy := sin*cx1 + cos*cy1 + (p1.Y+p2.Y)/2
+
+	angle := func(ux, uy float64) float64 { return math.Atan2(uy, ux) }
+	from := angle((x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((-x1-cx1)/rx, (-y1-cy1)/ry) - from
+	if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	} else if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	}
+
+	steps := int(math.Ceil(math.Abs(delta) / (math.Pi / 2) * motifCurveSteps))
+	pts := []geom.Coord{}
+	for i := 1; i <= steps; i++ {
+		a := from + delta*float64(i)/float64(steps)
+		x, y := rx*math.Cos(a), ry*math.Sin(a)
+		pts = append(pts, geom.Coord{X: cos*x - sin*y + cx, Y: sin*x + cos*y + cy})
+	}
+	return pts
+}
+
+const xlinkNs = "http://www.w3.org/1999/xlink"
+
+// motifID is the id of the <symbol> for the motif.
+const motifID = "motif"
+
+// addMotifSymbol adds a <symbol> with the motif, as it is at full size, for
+// cells to <use>.  Its lines are in a box from -1 to 1.
+func (sg *SVGGrid) addMotifSymbol(r *svgdata.Root) {
+	r.Attrs()["xmlns:xlink"] = xlinkNs
+	symbol := newSVGElement("symbol")
+	symbol.Attrs()["id"] = motifID
+	symbol.Attrs()["viewBox"] = "-1 -1 2 2"
+	// Without this the lines would be as thick as the stroke width times the
+	// size of each cell.
+	path := sg.Motif.path(func(p geom.Coord) geom.Coord { return p })
+	path.Attrs()["vector-effect"] = "non-scaling-stroke"
+	symbol.AddChild(path)
+	defs := newSVGElement("defs")
+	defs.AddChild(symbol)
+	r.AddChild(defs)
+}
+
+// motifNode returns the motif for c: either a <use> of the symbol or, if
+// inline, a path.  The motif is scaled so that it reaches as far from its
+// center as the circle would.
+func (sg *SVGGrid) motifNode(c Circle) svgdata.Node {
+	if sg.Motif.inline {
+		return sg.Motif.path(func(p geom.Coord) geom.Coord {
+			return sg.scaleCoord(c.Center.Plus(p.Times(c.Radius)))
+		})
+	}
+	use := newSVGElement("use")
+	use.Attrs()["xlink:href"] = "#" + motifID
+	use.Attrs()["x"] = fmt.Sprintf("%g", roundPath(sg.scaleValue(c.Center.X-c.Radius)))
+	use.Attrs()["y"] = fmt.Sprintf("%g", roundPath(sg.scaleValue(c.Center.Y-c.Radius)))
+	use.Attrs()["width"] = fmt.Sprintf("%g", roundPath(sg.scaleValue(2*c.Radius)))
+	use.Attrs()["height"] = use.Attrs()["width"]
+	return use
+}
+
+// path returns a path with all of the motif's lines, with each point passed
+// through f.
+func (m *Motif) path(f func(geom.Coord) geom.Coord) *svgdata.Path {
+	path := svgdata.NewPath()
+	for _, st := range m.strokes {
+		cmds := []svgdata.PathCommand{}
+		for i, p := range st.pts {
+			p = f(p)
+			cmd := byte('L')
+			if i == 0 {
+				cmd = 'M'
+			}
+			cmds = append(cmds, pathCommand(cmd, p.X, p.Y))
+		}
+		if st.closed {
+			cmds = append(cmds, svgdata.PathCommand{Command: 'Z'})
+		}
+		path.SubPaths = append(path.SubPaths, svgdata.SubPath{Commands: cmds})
+	}
+	return path
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/jbeda/geom"
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// NestOptions lays out several small pieces, such as coasters or ornaments,
+// together on one board.
+type NestOptions struct {
+	// The gap, in inches, between pieces and around the edge of the board.
+	// The default is 0.25.
+	Spacing float64 `json:"spacing,omitempty"`
+
+	Pieces []Piece `json:"pieces"`
+}
+
+// Piece is a single artwork to nest on the board.  Everything other than the
+// input and the size and shape of the canvas comes from the job.
+type Piece struct {
+	// The image to render.  Relative paths are relative to the job file.
+	Input string `json:"input"`
+
+	// The size of the canvas in inches.  The default is the canvas size of the
+	// job.
+	Width  float64 `json:"width,omitempty"`
+	Height float64 `json:"height,omitempty"`
+
+	// "rect" (default) or "ellipse".
+	Shape string `json:"shape,omitempty"`
+
+	// The number of copies to cut.  The default is 1.
+	Copies int `json:"copies,omitempty"`
+}
+
+// Validate checks each of the pieces against p.
+func (no *NestOptions) Validate(p Params) error {
+	if no.Spacing < 0 {
+		return fmt.Errorf("nest spacing must not be negative")
+	}
+	if len(no.Pieces) == 0 {
+		return fmt.Errorf("no pieces to nest")
+	}
+	for i, pc := range no.Pieces {
+		if pc.Input == "" {
+			return fmt.Errorf("piece %d has no input", i+1)
+		}
+		if pc.Shape != "" && pc.Shape != "rect" && pc.Shape != "ellipse" {
+			return fmt.Errorf("piece %d has an unknown shape: %q", i+1, pc.Shape)
+		}
+		if pc.Copies < 0 {
+			return fmt.Errorf("piece %d has a negative number of copies", i+1)
+		}
+		if err := pc.params(p).Validate(); err != nil {
+			return fmt.Errorf("piece %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (no *NestOptions) spacing() float64 {
+	if no.Spacing == 0 {
+		return 0.25
+	}
+	return no.Spacing
+}
+
+// params returns the params used to lay out the piece on its own, with the
+// board the same size as the canvas.
+func (pc *Piece) params(p Params) Params {
+	if pc.Width != 0 {
+		p.CanvasWidth = pc.Width
+	}
+	if pc.Height != 0 {
+		p.CanvasHeight = pc.Height
+	}
+	p.BoardWidth, p.BoardHeight = p.CanvasWidth, p.CanvasHeight
+	return p
+}
+
+func (pc *Piece) copies() int {
+	if pc.Copies == 0 {
+		return 1
+	}
+	return pc.Copies
+}
+
+// inside returns whether a circle with center c and radius r, on a canvas laid
+// out with params p, stays inside the piece's margin.
+func (pc *Piece) inside(p Params, c geom.Coord, r float64) bool {
+	if pc.Shape != "ellipse" {
+		return true
+	}
+	a := p.CanvasWidth/2 - p.CanvasMargin - r
+	b := p.CanvasHeight/2 - p.CanvasMargin - r
+	if a <= 0 || b <= 0 {
+		return false
+	}
+	dx, dy := (c.X-p.CanvasWidth/2)/a, (c.Y-p.CanvasHeight/2)/b
+	return dx*dx+dy*dy <= 1
+}
+
+// placement is a single copy of a piece on the board.
+type placement struct {
+	shape   string
+	rect    geom.Rect // the canvas on the board
+	circles []Circle
+}
+
+// shelfPack places rects of the given sizes in rows across a board of size w x
+// h, tallest first, with gap between them and around the edge.  It returns the
+// top left of each rect.
+func shelfPack(sizes []geom.Coord, w, h, gap float64) ([]geom.Coord, error) {
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return sizes[order[i]].Y > sizes[order[j]].Y })
+
+	pos := make([]geom.Coord, len(sizes))
+	x, y, shelf := gap, gap, 0.0
+	for placed, i := range order {
+		s := sizes[i]
+		if x+s.X+gap > w && x > gap {
+			x, y, shelf = gap, y+shelf+gap, 0
+		}
+		if x+s.X+gap > w || y+s.Y+gap > h {
+			return nil, fmt.Errorf("only %d of %d pieces fit on a %gx%g board", placed, len(sizes), w, h)
+		}
+		pos[i] = geom.Coord{X: x, Y: y}
+		x += s.X + gap
+		shelf = math.Max(shelf, s.Y)
+	}
+	return pos, nil
+}
+
+// interleave merges the circles of each placement into cut order.  Each cooling
+// group is cut across the whole board, taking one circle from each piece in
+// turn so that the heat is spread out rather than staying on one piece.
+func interleave(places []placement) []Circle {
+	circles := []Circle{}
+	for group := 0; group < numGroups; group++ {
+		lists := make([][]Circle, len(places))
+		for i, pl := range places {
+			for _, c := range pl.circles {
+				if c.Group == group {
+					lists[i] = append(lists[i], c)
+				}
+			}
+		}
+		for left := true; left; {
+			left = false
+			for i := range lists {
+				if len(lists[i]) > 0 {
+					circles = append(circles, lists[i][0])
+					lists[i] = lists[i][1:]
+					left = true
+				}
+			}
+		}
+	}
+	return circles
+}
+
+// nestInfo is the extra information for writing a nested board.
+type nestInfo struct {
+	places []placement
+}
+
+// nestOutlines returns the border of each piece on the board.
+func (sg *SVGGrid) nestOutlines() []svgdata.Node {
+	nodes := []svgdata.Node{}
+	for _, pl := range sg.nest.places {
+		r := pl.rect
+		if pl.shape != "ellipse" {
+			nodes = append(nodes, svgdata.NewRectXYWH(sg.scaleValue(r.Min.X), sg.scaleValue(r.Min.Y), sg.scaleValue(r.Width()), sg.scaleValue(r.Height())))
+			continue
+		}
+		// Quarter arcs put the ends of each arc at the extremes of the ellipse.
+		a, b := sg.scaleValue(r.Width()/2), sg.scaleValue(r.Height()/2)
+		c := sg.scaleCoord(r.Min.Plus(r.Max).Times(0.5))
+		path := svgdata.NewPath()
+		path.SubPaths = []svgdata.SubPath{{Commands: []svgdata.PathCommand{
+			pathCommand('M', c.X-a, c.Y),
+			pathCommand('A', a, b, 0, 0, 1, c.X, c.Y-b),
+			pathCommand('A', a, b, 0, 0, 1, c.X+a, c.Y),
+			pathCommand('A', a, b, 0, 0, 1, c.X, c.Y+b),
+			pathCommand('A', a, b, 0, 0, 1, c.X-a, c.Y),
+			{Command: 'Z'},
+		}}}
+		nodes = append(nodes, path)
+	}
+	return nodes
+}
+
+// renderNest lays out each piece of a nest job on its own and then packs them
+// all onto one board written to <outputPrefix>.svg.
+func renderNest(job *Job, outputPrefix string) ([]Circle, error) {
+	sizes := []geom.Coord{}
+	places := []placement{}
+	for _, pc := range job.Nest.Pieces {
+		p := pc.params(job.Params)
+		sg, err := newJobGrid(job, p)
+		if err != nil {
+			return nil, err
+		}
+		ic, err := newJobContent(job, pc.Input)
+		if err != nil {
+			return nil, err
+		}
+
+		circles := []Circle{}
+		for _, c := range sg.Layout(ic) {
+			if pc.inside(p, c.Center, p.MaxRadius()) {
+				circles = append(circles, c)
+			}
+		}
+		fmt.Printf("%s: %d circles\n", pc.Input, len(circles))
+
+		for n := 0; n < pc.copies(); n++ {
+			sizes = append(sizes, geom.Coord{X: p.CanvasWidth, Y: p.CanvasHeight})
+			places = append(places, placement{shape: pc.Shape, circles: circles})
+		}
+	}
+
+	pos, err := shelfPack(sizes, job.Params.BoardWidth, job.Params.BoardHeight, job.Nest.spacing())
+	if err != nil {
+		return nil, err
+	}
+	for i := range places {
+		places[i].rect = geom.Rect{Min: pos[i], Max: pos[i].Plus(sizes[i])}
+		moved := make([]Circle, len(places[i].circles))
+		for j, c := range places[i].circles {
+			c.Center = c.Center.Plus(pos[i])
+			moved[j] = c
+		}
+		places[i].circles = moved
+	}
+
+	sg, err := newJobGrid(job, job.Params)
+	if err != nil {
+		return nil, err
+	}
+	sg.nest = &nestInfo{places: places}
+	sg.Meta, err = NewMetadata(job)
+	if err != nil {
+		return nil, err
+	}
+	circles := interleave(places)
+	sg.WriteSVG(circles, outputPrefix)
+	fmt.Printf("%d pieces, %d circles\n", len(places), len(circles))
+	return circles, writeOutputs(job, circles, outputPrefix)
+}
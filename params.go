@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// Params are the physical dimensions of a piece.  Everything but UnitsPerInch
+// is in inches.  The defaults come from consts.go.
+type Params struct {
+	// The total width/height of each "cell"
+	Space float64 `json:"space"`
+	// The min between circles
+	Margin float64 `json:"margin"`
+	// The radius for "white" circles
+	MinRadius float64 `json:"minRadius"`
+
+	// The margin between edge of art and first circle
+	CanvasMargin float64 `json:"canvasMargin"`
+	CanvasWidth  float64 `json:"canvasWidth"`
+	CanvasHeight float64 `json:"canvasHeight"`
+
+	// The total size of the board
+	BoardWidth  float64 `json:"boardWidth"`
+	BoardHeight float64 `json:"boardHeight"`
+
+	// The units to use when rendering SVG.
+	UnitsPerInch float64 `json:"unitsPerInch"`
+	StrokeWidth  float64 `json:"strokeWidth"`
+}
+
+// DefaultParams returns the Params from consts.go.
+func DefaultParams() Params {
+	return Params{
+		Space:        cSpace,
+		Margin:       cMargin,
+		MinRadius:    cMinRadius,
+		CanvasMargin: canvasMargin,
+		CanvasWidth:  canvasWidth,
+		CanvasHeight: canvasHeight,
+		BoardWidth:   boardWidth,
+		BoardHeight:  boardHeight,
+		UnitsPerInch: unitsPerInch,
+		StrokeWidth:  strokeWidth,
+	}
+}
+
+// MaxRadius is the radius of "black" circles.
+func (p Params) MaxRadius() float64 {
+	return (p.Space - p.Margin) / 2.0
+}
+
+func (p Params) canvasInsideWidth() float64 {
+	return p.CanvasWidth - p.CanvasMargin*2.0
+}
+
+func (p Params) canvasInsideHeight() float64 {
+	return p.CanvasHeight - p.CanvasMargin*2.0
+}
+
+// Validate checks that the params describe a piece that can be made.
+func (p Params) Validate() error {
+	switch {
+	case p.Space <= 0:
+		return fmt.Errorf("space must be positive")
+	case p.Margin < 0 || p.Margin >= p.Space:
+		return fmt.Errorf("margin must be between 0 and the space (%g)", p.Space)
+	case p.MinRadius < 0 || p.MinRadius > p.MaxRadius():
+		return fmt.Errorf("minRadius must be between 0 and the max radius (%g)", p.MaxRadius())
+	case p.CanvasMargin < 0:
+		return fmt.Errorf("canvasMargin must not be negative")
+	case p.canvasInsideWidth() < p.Space || p.canvasInsideHeight() < p.Space:
+		return fmt.Errorf("canvas is too small for even one cell")
+	case p.CanvasWidth > p.BoardWidth || p.CanvasHeight > p.BoardHeight:
+		return fmt.Errorf("canvas (%gx%g) doesn't fit on the board (%gx%g)", p.CanvasWidth, p.CanvasHeight, p.BoardWidth, p.BoardHeight)
+	case p.UnitsPerInch <= 0:
+		return fmt.Errorf("unitsPerInch must be positive")
+	case p.StrokeWidth < 0:
+		return fmt.Errorf("strokeWidth must not be negative")
+	}
+	return nil
+}
+
+// paramFlag is a command line flag for one of the Params.
+type paramFlag struct {
+	name, usage string
+	field       func(p *Params) *float64
+}
+
+var paramFlagList = []paramFlag{
+	{"space", "the total width/height of each cell (inches)", func(p *Params) *float64 { return &p.Space }},
+	{"margin", "the min space between circles (inches)", func(p *Params) *float64 { return &p.Margin }},
+	{"min-radius", "the radius for white circles (inches)", func(p *Params) *float64 { return &p.MinRadius }},
+	{"canvas-margin", "the margin between the edge of the art and the first circle (inches)", func(p *Params) *float64 { return &p.CanvasMargin }},
+	{"canvas-width", "the width of the art (inches)", func(p *Params) *float64 { return &p.CanvasWidth }},
+	{"canvas-height", "the height of the art (inches)", func(p *Params) *float64 { return &p.CanvasHeight }},
+	{"board-width", "the width of the board (inches)", func(p *Params) *float64 { return &p.BoardWidth }},
+	{"board-height", "the height of the board (inches)", func(p *Params) *float64 { return &p.BoardHeight }},
+	{"units-per-inch", "SVG user units per inch", func(p *Params) *float64 { return &p.UnitsPerInch }},
+	{"stroke-width", "the width of cut lines (inches)", func(p *Params) *float64 { return &p.StrokeWidth }},
+}
+
+// ParamFlags holds a command line flag for each of the Params.  Only the flags
+// that are given override a job's Params.
+type ParamFlags struct {
+	fs   *flag.FlagSet
+	vals Params
+}
+
+// AddParamFlags registers a flag for each of the Params on fs.
+func AddParamFlags(fs *flag.FlagSet) *ParamFlags {
+	pf := &ParamFlags{fs: fs}
+	defaults := DefaultParams()
+	for _, f := range paramFlagList {
+		fs.Float64Var(f.field(&pf.vals), f.name, *f.field(&defaults), f.usage)
+	}
+	return pf
+}
+
+// Apply copies the flags that were set onto p.
+func (pf *ParamFlags) Apply(p *Params) {
+	set := map[string]bool{}
+	pf.fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, f := range paramFlagList {
+		if set[f.name] {
+			*f.field(p) = *f.field(&pf.vals)
+		}
+	}
+}
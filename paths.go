@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/jbeda/geom"
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// PathOptions writes circles as <path> elements rather than <circle> elements.
+// Some cutter software turns a <circle> into a coarse polygon or starts every
+// cut at the same point, which leaves a line of burn marks across the piece.
+type PathOptions struct {
+	// "arcs" (default) writes each circle as two arcs.  "beziers" writes four
+	// cubic Béziers for software that doesn't understand arcs.
+	Curves string `json:"curves,omitempty"`
+
+	// The angle, in degrees clockwise from 3 o'clock, that each cut starts at.
+	Start float64 `json:"start,omitempty"`
+
+	// Start each circle at a random angle instead.  The same Seed always gives
+	// the same angles.
+	Random bool  `json:"random,omitempty"`
+	Seed   int64 `json:"seed,omitempty"`
+}
+
+// Validate checks the path options.
+func (po *PathOptions) Validate() error {
+	if po.Curves != "" && po.Curves != "arcs" && po.Curves != "beziers" {
+		return fmt.Errorf("unknown path curves: %q", po.Curves)
+	}
+	return nil
+}
+
+// startAngles returns a function that gives the start angle, in radians, for
+// each circle in turn.
+func (po *PathOptions) startAngles() func() float64 {
+	if po.Random {
+		rnd := rand.New(rand.NewSource(po.Seed))
+		return func() float64 { return rnd.Float64() * 2 * math.Pi }
+	}
+	a := po.Start * math.Pi / 180
+	return func() float64 { return a }
+}
+
+// roundPath rounds to a thousandth of a user unit to keep the path data short.
+func roundPath(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+func pathCommand(cmd byte, params ...float64) svgdata.PathCommand {
+	for i := range params {
+		params[i] = roundPath(params[i])
+	}
+	return svgdata.PathCommand{Command: cmd, Params: params}
+}
+
+// arcTo appends commands for a clockwise arc around center, of radius r, from
+// angle from through sweep radians.  The current point must already be at the
+// start of the arc.  Everything is in user units.
+func (po *PathOptions) arcTo(cmds []svgdata.PathCommand, center geom.Coord, r, from, sweep float64) []svgdata.PathCommand {
+	at := func(a float64) geom.Coord {
+		return geom.Coord{X: center.X + r*math.Cos(a), Y: center.Y + r*math.Sin(a)}
+	}
+	tangent := func(a float64) geom.Coord {
+		return geom.Coord{X: -math.Sin(a), Y: math.Cos(a)}
+	}
+
+	// Arcs are split so that none go more than half way around, which keeps
+	// the arc flags unambiguous.  Béziers are kept to a quarter circle or less
+	// so that they stay close to round.
+	maxStep := math.Pi
+	if po.Curves == "beziers" {
+		maxStep = math.Pi / 2
+	}
+	n := int(math.Ceil(sweep/maxStep - 1e-9))
+	step := sweep / float64(n)
+	k := 4.0 / 3.0 * math.Tan(step/4) * r
+
+	for i := 0; i < n; i++ {
+		a0 := from + float64(i)*step
+		a1 := a0 + step
+		to := at(a1)
+		if po.Curves == "beziers" {
+			c1 := at(a0).Plus(tangent(a0).Times(k))
+			c2 := to.Minus(tangent(a1).Times(k))
+			cmds = append(cmds, pathCommand('C', c1.X, c1.Y, c2.X, c2.Y, to.X, to.Y))
+		} else {
+			cmds = append(cmds, pathCommand('A', r, r, 0, 0, 1, to.X, to.Y))
+		}
+	}
+	return cmds
+}
+
+// circlePath returns a closed path for a circle that starts and ends at angle
+// start.  Everything is in user units.
+func (po *PathOptions) circlePath(center geom.Coord, r, start float64) *svgdata.Path {
+	p0 := geom.Coord{X: center.X + r*math.Cos(start), Y: center.Y + r*math.Sin(start)}
+	cmds := []svgdata.PathCommand{pathCommand('M', p0.X, p0.Y)}
+	cmds = po.arcTo(cmds, center, r, start, 2*math.Pi)
+	cmds = append(cmds, svgdata.PathCommand{Command: 'Z'})
+
+	path := svgdata.NewPath()
+	path.SubPaths = []svgdata.SubPath{{Commands: cmds}}
+	return path
+}
+
+// tabbedPath returns a path for a circle that is left uncut in tabs places,
+// spaced evenly around it.  Each gap is gap radians wide and the first cut
+// starts at angle start.
+func (po *PathOptions) tabbedPath(center geom.Coord, r, start float64, tabs int, gap float64) *svgdata.Path {
+	step := 2 * math.Pi / float64(tabs)
+
+	path := svgdata.NewPath()
+	for i := 0; i < tabs; i++ {
+		from := start + float64(i)*step
+		p0 := geom.Coord{X: center.X + r*math.Cos(from), Y: center.Y + r*math.Sin(from)}
+		cmds := []svgdata.PathCommand{pathCommand('M', p0.X, p0.Y)}
+		cmds = po.arcTo(cmds, center, r, from, step-gap)
+		path.SubPaths = append(path.SubPaths, svgdata.SubPath{Commands: cmds})
+	}
+	return path
+}
+
+// TabOptions leaves small uncut gaps in larger circles so that the plugs stay
+// in place until they are popped out by hand.  Otherwise, in thin material,
+// they can drop through the bed or tilt up and get cut again.
+type TabOptions struct {
+	// The number of tabs on each circle.  The default is 2.
+	Count int `json:"count,omitempty"`
+
+	// The width of each tab in inches.  The default is 0.015.
+	Width float64 `json:"width,omitempty"`
+
+	// Only circles with at least this radius, in inches, get tabs.
+	MinRadius float64 `json:"minRadius,omitempty"`
+}
+
+// Validate checks the tab options for circles up to maxRadius.
+func (to *TabOptions) Validate(maxRadius float64) error {
+	if to.Count < 0 {
+		return fmt.Errorf("tab count must not be negative")
+	}
+	if to.Width < 0 {
+		return fmt.Errorf("tab width must not be negative")
+	}
+	if to.MinRadius < 0 || to.MinRadius > maxRadius {
+		return fmt.Errorf("tab min radius %g is outside of the range 0 to the largest circle (%g), so no circle would get tabs", to.MinRadius, maxRadius)
+	}
+	if _, ok := to.gap(maxRadius); !ok {
+		return fmt.Errorf("%d tabs %gin wide take up too much of even the largest circles", to.count(), to.width())
+	}
+	return nil
+}
+
+func (to *TabOptions) count() int {
+	if to.Count == 0 {
+		return 2
+	}
+	return to.Count
+}
+
+func (to *TabOptions) width() float64 {
+	if to.Width == 0 {
+		return 0.015
+	}
+	return to.Width
+}
+
+// gap returns the angle, in radians, that each tab takes up on a circle of
+// radius r.  ok is false if the circle shouldn't get tabs, either because it
+// is too small or because the tabs would take up a quarter of it or more.
+func (to *TabOptions) gap(r float64) (gap float64, ok bool) {
+	if to == nil || r < to.MinRadius {
+		return 0, false
+	}
+	gap = to.width() / r
+	return gap, gap*float64(to.count()) < math.Pi/2
+}
+
+// untabbed returns the number of circles that are big enough for tabs but are
+// cut without them because the tabs would take up too much of them.
+func (to *TabOptions) untabbed(circles []Circle) int {
+	n := 0
+	for _, c := range circles {
+		if _, ok := to.gap(c.Radius); !ok && c.Radius >= to.MinRadius {
+			n++
+		}
+	}
+	return n
+}
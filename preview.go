@@ -0,0 +1,66 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/jbeda/geom"
+)
+
+// Colors used for previews: the holes show the dark backing through light
+// material.
+var (
+	previewMaterial = color.NRGBA{R: 0xe8, G: 0xd2, B: 0xb0, A: 0xff}
+	previewHole     = color.NRGBA{R: 0x20, G: 0x20, B: 0x20, A: 0xff}
+)
+
+// RenderPreview draws circles as they will look once cut, as holes in the
+// material, over the part of the board given by area.  ppi is the number of
+// pixels per inch.  Edges are anti-aliased.
+func RenderPreview(circles []Circle, area geom.Rect, ppi float64) *image.NRGBA {
+	w := int(math.Ceil(area.Width() * ppi))
+	h := int(math.Ceil(area.Height() * ppi))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i+0] = previewMaterial.R
+		img.Pix[i+1] = previewMaterial.G
+		img.Pix[i+2] = previewMaterial.B
+		img.Pix[i+3] = previewMaterial.A
+	}
+
+	mix := func(a, b uint8, t float64) uint8 {
+		return uint8(float64(a)*(1-t) + float64(b)*t + 0.5)
+	}
+
+	for _, c := range circles {
+		cx := (c.Center.X - area.Min.X) * ppi
+		cy := (c.Center.Y - area.Min.Y) * ppi
+		r := c.Radius * ppi
+
+		x0, x1 := int(math.Floor(cx-r-1)), int(math.Ceil(cx+r+1))
+		y0, y1 := int(math.Floor(cy-r-1)), int(math.Ceil(cy+r+1))
+		for y := y0; y <= y1; y++ {
+			if y < 0 || y >= h {
+				continue
+			}
+			for x := x0; x <= x1; x++ {
+				if x < 0 || x >= w {
+					continue
+				}
+				// Approximate the covered fraction of the pixel from the
+				// distance of its center to the edge of the circle.
+				d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+				cover := clampUnit(r - d + 0.5)
+				if cover == 0 {
+					continue
+				}
+				i := img.PixOffset(x, y)
+				img.Pix[i+0] = mix(img.Pix[i+0], previewHole.R, cover)
+				img.Pix[i+1] = mix(img.Pix[i+1], previewHole.G, cover)
+				img.Pix[i+2] = mix(img.Pix[i+2], previewHole.B, cover)
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// diffusionWeight spreads part of the quantization error of a cell to a
+// neighbour.  along is the offset in the direction the grid is walked (y, the
+// inner loop in RenderGrid) and next is the offset into following columns (x).
+type diffusionWeight struct {
+	along, next int
+	weight      float64
+}
+
+var diffusionKernels = map[string][]diffusionWeight{
+	"none": nil,
+	"floyd-steinberg": {
+		{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	// Atkinson only spreads 3/4 of the error which keeps more contrast in
+	// light and dark areas.
+	"atkinson": {
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8},
+		{-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8},
+		{0, 2, 1.0 / 8},
+	},
+	"jarvis": {
+		{1, 0, 7.0 / 48}, {2, 0, 5.0 / 48},
+		{-2, 1, 3.0 / 48}, {-1, 1, 5.0 / 48}, {0, 1, 7.0 / 48}, {1, 1, 5.0 / 48}, {2, 1, 3.0 / 48},
+		{-2, 2, 1.0 / 48}, {-1, 2, 3.0 / 48}, {0, 2, 5.0 / 48}, {1, 2, 3.0 / 48}, {2, 2, 1.0 / 48},
+	},
+}
+
+// maxQuantizeRadii is the most radii a Quantizer can have.  Every cell is
+// compared with every radius, and a drill file can't have more tools than this.
+const maxQuantizeRadii = 99
+
+// Quantizer snaps circle radii to a fixed set of sizes, such as a set of drill
+// bits or punches.  The difference in tone is carried to neighbouring cells
+// with error diffusion.
+type Quantizer struct {
+	// The allowed radii in inches.  A radius of 0 means no hole.
+	Radii []float64 `json:"radii"`
+
+	// One of "floyd-steinberg" (default), "atkinson", "jarvis" or "none".
+	Diffusion string `json:"diffusion,omitempty"`
+}
+
+// Validate checks that the quantizer can be used with circles no bigger than
+// maxRadius.
+func (q *Quantizer) Validate(maxRadius float64) error {
+	if len(q.Radii) == 0 {
+		return fmt.Errorf("no radii given to quantize to")
+	}
+	if len(q.Radii) > maxQuantizeRadii {
+		return fmt.Errorf("%d radii given to quantize to, more than %d", len(q.Radii), maxQuantizeRadii)
+	}
+	for _, r := range q.Radii {
+		if r < 0 || r > maxRadius {
+			return fmt.Errorf("radius %g is outside of the range 0 to %g", r, maxRadius)
+		}
+	}
+	if _, ok := diffusionKernels[q.diffusion()]; !ok {
+		return fmt.Errorf("unknown diffusion: %q", q.Diffusion)
+	}
+	return nil
+}
+
+func (q *Quantizer) diffusion() string {
+	if q.Diffusion == "" {
+		return "floyd-steinberg"
+	}
+	return q.Diffusion
+}
+
+// Quantize replaces each radius in r, indexed as [x][y], with one of the
+// allowed radii.  Tone is measured as the area of the circle relative to a
+// circle of maxRadius.  Cells set to noCircle are left alone and don't take
+// part in the error diffusion.  It stops early with ctx's error once ctx is
+// done.
+func (q *Quantizer) Quantize(ctx context.Context, r [][]float64, maxRadius float64) error {
+	allowed := append([]float64(nil), q.Radii...)
+	sort.Float64s(allowed)
+
+	tone := func(rad float64) float64 {
+		return (rad * rad) / (maxRadius * maxRadius)
+	}
+
+	xNum := len(r)
+	if xNum == 0 {
+		return nil
+	}
+	yNum := len(r[0])
+
+	errs := make([][]float64, xNum)
+	for x := range errs {
+		errs[x] = make([]float64, yNum)
+	}
+
+	kernel := diffusionKernels[q.diffusion()]
+	for x := 0; x < xNum; x++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for y := 0; y < yNum; y++ {
+			if r[x][y] == noCircle {
+				continue
+			}
+			want := tone(r[x][y]) + errs[x][y]
+
+			best := allowed[0]
+			for _, a := range allowed[1:] {
+				if math.Abs(tone(a)-want) < math.Abs(tone(best)-want) {
+					best = a
+				}
+			}
+			r[x][y] = best
+
+			e := want - tone(best)
+			for _, k := range kernel {
+				nx, ny := x+k.next, y+k.along
+				if nx < 0 || nx >= xNum || ny < 0 || ny >= yNum || r[nx][ny] == noCircle {
+					continue
+				}
+				errs[nx][ny] += e * k.weight
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rerenderMain implements the rerender command.  It reads the job that was
+// stored in a circle-art SVG and renders it again, with any flags overriding
+// the stored settings.
+func rerenderMain(args []string) {
+	fs := flag.NewFlagSet("rerender", flag.ExitOnError)
+	input := fs.String("input", "", "render this image instead of the one recorded in the SVG")
+	out := fs.String("o", "", "output prefix (default <svg-file>-rerender)")
+	jf := addJobFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "USAGE: circle-art rerender [flags] <svg-file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	fn := fs.Arg(0)
+
+	meta, err := ReadMetadata(fn)
+	check(err)
+	job := meta.Job
+
+	if job.Nest != nil {
+		for i, pc := range job.Nest.Pieces {
+			job.Nest.Pieces[i].Input = findInput(pc.Input, fn)
+			h, err := fileSHA256(job.Nest.Pieces[i].Input)
+			check(err)
+			if i < len(meta.PieceSHA256) && h != meta.PieceSHA256[i] {
+				fmt.Fprintf(os.Stderr, "WARNING: %s has changed since %s was made\n", job.Nest.Pieces[i].Input, fn)
+			}
+		}
+	}
+	if job.Composite != nil {
+		job.Composite.mapFiles(func(in string) string { return findInput(in, fn) })
+	}
+	if job.Text != nil {
+		job.Text.Font = findInput(job.Text.Font, fn)
+	}
+	if job.Motif != nil {
+		job.Motif.File = findInput(job.Motif.File, fn)
+	}
+	if *input != "" {
+		job.Input = *input
+		job.Source = ""
+		job.Composite = nil
+		job.Text = nil
+	} else if job.Input != "" {
+		job.Input = findInput(job.Input, fn)
+		h, err := fileSHA256(job.Input)
+		check(err)
+		if h != meta.InputSHA256 {
+			fmt.Fprintf(os.Stderr, "WARNING: %s has changed since %s was made\n", job.Input, fn)
+		}
+	}
+	jf.apply(job)
+
+	outputPrefix := *out
+	if outputPrefix == "" {
+		outputPrefix = strings.TrimSuffix(fn, filepath.Ext(fn)) + "-rerender"
+	}
+	_, err = renderJob(job, outputPrefix)
+	check(err)
+}
+
+// findInput looks for an input recorded in svgFile.  Relative paths are tried
+// as they are and then relative to the directory of svgFile.
+func findInput(input, svgFile string) string {
+	if filepath.IsAbs(input) {
+		return input
+	}
+	if _, err := os.Stat(input); err == nil {
+		return input
+	}
+	alt := filepath.Join(filepath.Dir(svgFile), input)
+	if _, err := os.Stat(alt); err == nil {
+		return alt
+	}
+	return input
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/jbeda/geom"
+)
+
+// renderImage validates job and lays it out using img in place of job.Input.
+// Only jobs that make a single piece from img alone, with no more than
+// s.maxCells cells, can be rendered this way.  It stops early with ctx's error once ctx is done.
+func (s *server) renderImage(ctx context.Context, job *Job, img image.Image) (*SVGGrid, []Circle, error) {
+	fillCaptionDates(job, time.Now())
+	if err := job.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if job.Nest != nil || job.Tiles != nil || job.Layers != nil {
+		return nil, nil, fmt.Errorf("nested, tiled and layered jobs can only be rendered from the command line")
+	}
+	// These read files named in the job, which mustn't be allowed to reach
+	// the server's disk.
+	if job.Motif != nil || job.Composite != nil || job.Text != nil {
+		return nil, nil, fmt.Errorf("motifs, compositions and text read files, so they can only be rendered from the command line")
+	}
+	// This is worked out the same way as in NewSVGGrid, but in floating
+	// point so that a tiny space can't overflow it.
+	p := job.Params
+	cells := math.Floor(p.canvasInsideWidth()/p.Space) * math.Floor(p.canvasInsideHeight()/p.Space)
+	if cells > float64(s.maxCells) {
+		return nil, nil, fmt.Errorf("job has %.0f cells, which is more than %d", cells, s.maxCells)
+	}
+
+	sg, err := newJobGrid(job, job.Params)
+	if err != nil {
+		return nil, nil, err
+	}
+	ic, err := NewImageContentContext(ctx, img, job.Tone)
+	if err != nil {
+		return nil, nil, err
+	}
+	ic.Detail = job.Detail
+	circles, err := sg.LayoutContext(ctx, ic)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sg, circles, nil
+}
+
+// checkPreview returns an error if a PNG preview of sg at ppi would have more
+// than s.maxPixels pixels.
+func (s *server) checkPreview(sg *SVGGrid, ppi float64) error {
+	if px := sg.p.CanvasWidth * ppi * sg.p.CanvasHeight * ppi; px > float64(s.maxPixels) {
+		return fmt.Errorf("preview would be %.0f pixels, which is more than %d", px, s.maxPixels)
+	}
+	return nil
+}
+
+// canvasArea returns the canvas on the board.
+func (sg *SVGGrid) canvasArea() geom.Rect {
+	xOffset, yOffset := sg.canvasOffset()
+	return geom.Rect{
+		Min: geom.Coord{X: xOffset, Y: yOffset},
+		Max: geom.Coord{X: xOffset + sg.p.CanvasWidth, Y: yOffset + sg.p.CanvasHeight},
+	}
+}
+
+// preview is an image uploaded to the page.
+type preview struct {
+	img      image.Image
+	name     string
+	sha      string
+	lastUsed time.Time
+}
+
+// server is the state of the serve command.
+type server struct {
+	// The images uploaded to the page, by session.  Each upload starts a new
+	// session, so one client never renders another's image.  Only the most
+	// recently used maxSessions are kept.
+	mu          sync.Mutex
+	previews    map[string]*preview
+	maxSessions int
+
+	// Renders wait for a slot in pool so that only so many run at once.
+	pool      chan struct{}
+	timeout   time.Duration
+	maxUpload int64
+	maxPixels int
+	maxCells  int
+}
+
+var (
+	errBusy     = errors.New("server busy, try again later")
+	errTimedOut = errors.New("render timed out")
+)
+
+// run calls render with ctx once there is a free worker and returns its
+// error.  If ctx is done first errTimedOut is returned without waiting.  render
+// should stop soon after ctx is done, as it holds its worker until it returns.
+func (s *server) run(ctx context.Context, render func(context.Context) error) error {
+	select {
+	case s.pool <- struct{}{}:
+	case <-ctx.Done():
+		return errBusy
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() { <-s.pool }()
+		done <- render(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if ctx.Err() != nil {
+			return errTimedOut
+		}
+		return err
+	case <-ctx.Done():
+		return errTimedOut
+	}
+}
+
+// decodeImage decodes an uploaded image, refusing anything with more than
+// maxPixels pixels before it is decompressed.
+func (s *server) decodeImage(d []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(d))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > s.maxPixels {
+		return nil, fmt.Errorf("image is %dx%d, which is more than %d pixels", cfg.Width, cfg.Height, s.maxPixels)
+	}
+	return imaging.Decode(bytes.NewReader(d))
+}
+
+// addPreview keeps pv and returns the session it can be rendered with.
+func (s *server) addPreview(pv *preview) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.previews) >= s.maxSessions {
+		oldest := ""
+		for k, v := range s.previews {
+			if oldest == "" || v.lastUsed.Before(s.previews[oldest].lastUsed) {
+				oldest = k
+			}
+		}
+		delete(s.previews, oldest)
+	}
+	pv.lastUsed = time.Now()
+	s.previews[id] = pv
+	return id, nil
+}
+
+// getPreview returns the image uploaded in session, or nil if there isn't
+// one.
+func (s *server) getPreview(session string) *preview {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pv := s.previews[session]
+	if pv != nil {
+		pv.lastUsed = time.Now()
+	}
+	return pv
+}
+
+// statusFor returns the HTTP status for an error from run.
+func statusFor(err error) int {
+	switch err {
+	case errBusy:
+		return http.StatusServiceUnavailable
+	case errTimedOut:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadRequest
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(servePage))
+}
+
+// handleDefaults returns the default job so the page starts with the same
+// settings as the command line.
+func (s *server) handleDefaults(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewJob())
+}
+
+func (s *server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST an image", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	f, hdr, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	d, err := ioutil.ReadAll(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	img, err := s.decodeImage(d)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't read %s: %v", hdr.Filename, err), http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256(d)
+	pv := &preview{img: img, name: filepath.Base(hdr.Filename), sha: hex.EncodeToString(sum[:])}
+	session, err := s.addPreview(pv)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session": session,
+		"name":    pv.name,
+		"width":   img.Bounds().Dx(),
+		"height":  img.Bounds().Dy(),
+	})
+}
+
+// handleRender renders the image uploaded in ?session= with the job POSTed as
+// JSON.  The result is an SVG, or a PNG preview with ?format=png.
+func (s *server) handleRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST a job", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	job := NewJob()
+	if err := json.NewDecoder(r.Body).Decode(job); err != nil {
+		http.Error(w, fmt.Sprintf("error parsing job: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	pv := s.getPreview(r.URL.Query().Get("session"))
+	if pv == nil {
+		http.Error(w, "upload an image first", http.StatusBadRequest)
+		return
+	}
+	img, name, sha := pv.img, pv.name, pv.sha
+	job.Input = name
+	asPNG := r.URL.Query().Get("format") == "png"
+	ppi := 100.0
+	if v, err := strconv.ParseFloat(r.URL.Query().Get("ppi"), 64); err == nil && v > 0 && v <= 600 {
+		ppi = v
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	var out bytes.Buffer
+	var stats *Stats
+	err := s.run(ctx, func(ctx context.Context) error {
+		sg, circles, err := s.renderImage(ctx, job, img)
+		if err != nil {
+			return err
+		}
+		sg.Meta = &Metadata{Generator: "circle-art", Job: job, InputSHA256: sha}
+		stats = NewStats(sg, circles)
+		if asPNG {
+			if err := s.checkPreview(sg, ppi); err != nil {
+				return err
+			}
+			png.Encode(&out, RenderPreview(circles, sg.canvasArea(), ppi))
+		} else {
+			out.Write(sg.MarshalSVG(circles, strings.TrimSuffix(name, filepath.Ext(name))))
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("X-Circles", strconv.Itoa(stats.Circles))
+	w.Header().Set("X-Skipped", strconv.Itoa(stats.Skipped))
+	if asPNG {
+		w.Header().Set("Content-Type", "image/png")
+	} else {
+		w.Header().Set("Content-Type", "image/svg+xml")
+	}
+	w.Write(out.Bytes())
+}
+
+// serveMain implements the serve command, a local web page for trying out
+// settings with a live preview.
+func serveMain(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of renders to run at once")
+	timeout := fs.Duration("timeout", 30*time.Second, "longest time to spend on a request, including waiting for a worker")
+	maxUpload := fs.Int64("max-upload", 20, "largest request to accept, in megabytes")
+	maxPixels := fs.Int("max-pixels", 50000000, "largest image or preview to accept, in pixels")
+	maxCells := fs.Int("max-cells", 250000, "largest number of cells a job can have")
+	maxSessions := fs.Int("max-sessions", 8, "number of images uploaded to the page to keep")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "USAGE: circle-art serve [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	s := &server{
+		previews:    map[string]*preview{},
+		maxSessions: *maxSessions,
+		pool:        make(chan struct{}, *workers),
+		timeout:     *timeout,
+		maxUpload:   *maxUpload << 20,
+		maxPixels:   *maxPixels,
+		maxCells:    *maxCells,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/defaults", s.handleDefaults)
+	mux.HandleFunc("/upload", s.handleUpload)
+	mux.HandleFunc("/render", s.handleRender)
+	mux.HandleFunc("/api/render", s.handleAPIRender)
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("Serving on http://%s/\n", *addr)
+	check(srv.ListenAndServe())
+}
@@ -0,0 +1,231 @@
+package main
+
+// servePage is the page for the serve command.  It is self contained so that
+// it works offline.
+const servePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>circle-art</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+#controls { width: 300px; padding: 12px; overflow-y: auto; background: #f4f4f4; border-right: 1px solid #ccc; }
+#controls h1 { font-size: 18px; margin: 0 0 12px 0; }
+#controls h2 { font-size: 14px; margin: 16px 0 4px 0; }
+#controls label { display: block; font-size: 13px; margin-top: 6px; }
+#controls input[type=range] { width: 100%; }
+#controls .value { float: right; font-family: monospace; }
+#controls button { margin: 4px 4px 0 0; }
+#main { flex: 1; display: flex; flex-direction: column; }
+#status { padding: 8px 12px; border-bottom: 1px solid #ccc; font-size: 13px; min-height: 16px; }
+#status.error { color: #b00; }
+#preview { flex: 1; overflow: auto; padding: 12px; background: #fff; }
+#preview img { max-width: 100%; max-height: 100%; border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<div id="controls">
+  <h1>circle-art</h1>
+  <input type="file" id="image" accept="image/*">
+
+  <h2>Grid</h2>
+  <label>Space <span class="value" id="space-v"></span>
+    <input type="range" id="space" data-path="params.space" min="0.05" max="0.5" step="0.005"></label>
+  <label>Margin <span class="value" id="margin-v"></span>
+    <input type="range" id="margin" data-path="params.margin" min="0" max="0.1" step="0.001"></label>
+  <label>Min radius <span class="value" id="minRadius-v"></span>
+    <input type="range" id="minRadius" data-path="params.minRadius" min="0" max="0.1" step="0.001"></label>
+
+  <h2>Layout</h2>
+  <label>Canvas width <span class="value" id="canvasWidth-v"></span>
+    <input type="range" id="canvasWidth" data-path="params.canvasWidth" min="1" max="40" step="0.25"></label>
+  <label>Canvas height <span class="value" id="canvasHeight-v"></span>
+    <input type="range" id="canvasHeight" data-path="params.canvasHeight" min="1" max="40" step="0.25"></label>
+  <label>Canvas margin <span class="value" id="canvasMargin-v"></span>
+    <input type="range" id="canvasMargin" data-path="params.canvasMargin" min="0" max="1" step="0.05"></label>
+  <label>Board width <span class="value" id="boardWidth-v"></span>
+    <input type="range" id="boardWidth" data-path="params.boardWidth" min="1" max="40" step="0.25"></label>
+  <label>Board height <span class="value" id="boardHeight-v"></span>
+    <input type="range" id="boardHeight" data-path="params.boardHeight" min="1" max="40" step="0.25"></label>
+
+  <h2>Tone</h2>
+  <label>Gamma <span class="value" id="gamma-v"></span>
+    <input type="range" id="gamma" min="0.2" max="3" step="0.05" value="1"></label>
+  <label>Curve
+    <select id="shape"><option value="radius">radius</option><option value="area">area</option></select></label>
+  <label>Skip below <span class="value" id="skip-v"></span>
+    <input type="range" id="skip" data-path="curve.skip" min="0" max="1" step="0.01"></label>
+  <label>Clamp above (0 is off) <span class="value" id="clamp-v"></span>
+    <input type="range" id="clamp" data-path="curve.clamp" min="0" max="1" step="0.01"></label>
+  <label>Alpha threshold <span class="value" id="alpha-v"></span>
+    <input type="range" id="alpha" min="0.01" max="1" step="0.01" value="0.5"></label>
+
+  <h2>Output</h2>
+  <label><input type="checkbox" id="engrave"> Engrave instead of cut</label>
+  <label>Circles as
+    <select id="paths"><option value="">circles</option><option value="arcs">arcs</option><option value="beziers">beziers</option></select></label>
+
+  <h2>Download</h2>
+  <button id="dl-svg" disabled>SVG</button>
+  <button id="dl-png" disabled>Preview PNG</button>
+  <button id="dl-job">Job file</button>
+</div>
+<div id="main">
+  <div id="status">Choose an image to start.</div>
+  <div id="preview"><img id="out" alt=""></div>
+</div>
+<script>
+(function() {
+  var job = null, name = "circle-art", session = null;
+  var svgURL = null, timer = null, pending = null;
+
+  function $(id) { return document.getElementById(id); }
+
+  function status(msg, error) {
+    $("status").textContent = msg;
+    $("status").className = error ? "error" : "";
+  }
+
+  function getPath(obj, path) {
+    var parts = path.split(".");
+    for (var i = 0; i < parts.length; i++) { obj = obj ? obj[parts[i]] : undefined; }
+    return obj;
+  }
+
+  function setPath(obj, path, v) {
+    var parts = path.split(".");
+    for (var i = 0; i < parts.length - 1; i++) {
+      if (!obj[parts[i]]) { obj[parts[i]] = {}; }
+      obj = obj[parts[i]];
+    }
+    obj[parts[parts.length - 1]] = v;
+  }
+
+  function showValue(el) {
+    var v = $(el.id + "-v");
+    if (v) { v.textContent = el.value; }
+  }
+
+  // buildJob collects the settings from the controls into a job.
+  function buildJob() {
+    var j = JSON.parse(JSON.stringify(job));
+    var ranges = document.querySelectorAll("input[data-path]");
+    for (var i = 0; i < ranges.length; i++) {
+      setPath(j, ranges[i].getAttribute("data-path"), parseFloat(ranges[i].value));
+    }
+    j.curve = j.curve || {};
+    j.curve.shape = $("shape").value;
+    var gamma = parseFloat($("gamma").value);
+    j.tone = (j.tone || []).filter(function(op) { return op.op !== "gamma"; });
+    if (gamma !== 1) { j.tone.push({op: "gamma", gamma: gamma}); }
+    j.alpha = {threshold: parseFloat($("alpha").value)};
+    delete j.engrave;
+    if ($("engrave").checked) { j.engrave = {}; }
+    delete j.paths;
+    if ($("paths").value) { j.paths = {curves: $("paths").value}; }
+    return j;
+  }
+
+  function render() {
+    if (!session) { return; }
+    if (pending) { pending.abort(); }
+    pending = new AbortController();
+    status("Rendering...");
+    fetch("/render?session=" + session, {method: "POST", body: JSON.stringify(buildJob()), signal: pending.signal})
+      .then(function(resp) {
+        if (!resp.ok) { return resp.text().then(function(t) { throw new Error(t); }); }
+        var circles = resp.headers.get("X-Circles"), skipped = resp.headers.get("X-Skipped");
+        return resp.blob().then(function(b) {
+          if (svgURL) { URL.revokeObjectURL(svgURL); }
+          svgURL = URL.createObjectURL(b);
+          $("out").src = svgURL;
+          $("dl-svg").disabled = false;
+          $("dl-png").disabled = false;
+          status(name + ": " + circles + " circles, " + skipped + " skipped");
+        });
+      })
+      .catch(function(e) {
+        if (e.name !== "AbortError") { status(e.message, true); }
+      });
+  }
+
+  function schedule() {
+    clearTimeout(timer);
+    timer = setTimeout(render, 150);
+  }
+
+  function download(url, fn) {
+    var a = document.createElement("a");
+    a.href = url;
+    a.download = fn;
+    document.body.appendChild(a);
+    a.click();
+    a.remove();
+  }
+
+  $("image").addEventListener("change", function() {
+    var f = this.files[0];
+    if (!f) { return; }
+    var fd = new FormData();
+    fd.append("image", f);
+    status("Uploading " + f.name + "...");
+    fetch("/upload", {method: "POST", body: fd})
+      .then(function(resp) {
+        if (!resp.ok) { return resp.text().then(function(t) { throw new Error(t); }); }
+        return resp.json();
+      })
+      .then(function(info) {
+        name = info.name.replace(/\.[^.]*$/, "");
+        session = info.session;
+        render();
+      })
+      .catch(function(e) { status(e.message, true); });
+  });
+
+  $("dl-svg").addEventListener("click", function() {
+    if (svgURL) { download(svgURL, name + ".svg"); }
+  });
+
+  $("dl-png").addEventListener("click", function() {
+    fetch("/render?session=" + session + "&format=png&ppi=150", {method: "POST", body: JSON.stringify(buildJob())})
+      .then(function(resp) {
+        if (!resp.ok) { return resp.text().then(function(t) { throw new Error(t); }); }
+        return resp.blob();
+      })
+      .then(function(b) {
+        var url = URL.createObjectURL(b);
+        download(url, name + ".png");
+        setTimeout(function() { URL.revokeObjectURL(url); }, 1000);
+      })
+      .catch(function(e) { status(e.message, true); });
+  });
+
+  $("dl-job").addEventListener("click", function() {
+    var j = buildJob();
+    delete j.input;
+    var b = new Blob([JSON.stringify(j, null, 2)], {type: "application/json"});
+    var url = URL.createObjectURL(b);
+    download(url, name + ".json");
+    setTimeout(function() { URL.revokeObjectURL(url); }, 1000);
+  });
+
+  fetch("/defaults").then(function(resp) { return resp.json(); }).then(function(j) {
+    job = j;
+    var ranges = document.querySelectorAll("input[data-path]");
+    for (var i = 0; i < ranges.length; i++) {
+      var v = getPath(job, ranges[i].getAttribute("data-path"));
+      ranges[i].value = v === undefined ? 0 : v;
+    }
+    var inputs = document.querySelectorAll("#controls input, #controls select");
+    for (var i = 0; i < inputs.length; i++) {
+      if (inputs[i].type === "file") { continue; }
+      showValue(inputs[i]);
+      inputs[i].addEventListener("input", function() { showValue(this); schedule(); });
+      inputs[i].addEventListener("change", schedule);
+    }
+  });
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jbeda/geom"
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// ShapeOptions cuts each cell as a polygon instead of a circle.  A shape has
+// the same area as the circle it replaces so the tone of the piece is the
+// same whatever the shape, up to the size where it would reach past the
+// biggest circle.
+type ShapeOptions struct {
+	// One of "square", "diamond", "hexagon", "triangle", "star" or "plus".
+	Kind string `json:"kind"`
+
+	// How far each shape is turned, in degrees clockwise.
+	Rotation float64 `json:"rotation,omitempty"`
+}
+
+// shapeKinds are the corners of each shape, around a center of (0, 0), before
+// it is scaled to the right area.  y goes down, as in SVG.
+var shapeKinds = map[string]func() []geom.Coord{
+	"square":   func() []geom.Coord { return regularPolygon(4, 45, 1) },
+	"diamond":  func() []geom.Coord { return regularPolygon(4, 0, 1) },
+	"hexagon":  func() []geom.Coord { return regularPolygon(6, 0, 1) },
+	"triangle": func() []geom.Coord { return regularPolygon(3, -90, 1) },
+	"star": func() []geom.Coord {
+		// The inner corners of a regular five pointed star are where the
+		// lines between its points cross.
+		outer := regularPolygon(5, -90, 1)
+		inner := regularPolygon(5, -54, math.Cos(2*math.Pi/5)/math.Cos(math.Pi/5))
+		pts := []geom.Coord{}
+		for i := range outer {
+			pts = append(pts, outer[i], inner[i])
+		}
+		return pts
+	},
+	"plus": func() []geom.Coord {
+		const w = 1.0 / 3
+		return []geom.Coord{
+			{X: -w, Y: -1}, {X: w, Y: -1}, {X: w, Y: -w}, {X: 1, Y: -w},
+			{X: 1, Y: w}, {X: w, Y: w}, {X: w, Y: 1}, {X: -w, Y: 1},
+			{X: -w, Y: w}, {X: -1, Y: w}, {X: -1, Y: -w}, {X: -w, Y: -w},
+		}
+	},
+}
+
+// regularPolygon returns n corners, at distance r from the center, starting
+// at angle start in degrees.
+func regularPolygon(n int, start, r float64) []geom.Coord {
+	pts := []geom.Coord{}
+	for i := 0; i < n; i++ {
+		a := (start + 360*float64(i)/float64(n)) * math.Pi / 180
+		pts = append(pts, geom.Coord{X: r * math.Cos(a), Y: r * math.Sin(a)})
+	}
+	return pts
+}
+
+// Validate checks the shape options.
+func (so *ShapeOptions) Validate() error {
+	if _, ok := shapeKinds[so.Kind]; !ok {
+		return fmt.Errorf("unknown shape %q", so.Kind)
+	}
+	return nil
+}
+
+// outline returns the corners of the shape, turned by Rotation, with the same
+// area as a circle of radius 1.  Shapes such as triangles are moved so that
+// they are centered across and down, which lets them be bigger before they
+// reach the edge of their cell.
+func (so *ShapeOptions) outline() []geom.Coord {
+	pts := shapeKinds[so.Kind]()
+
+	area := 0.0
+	for i, a := range pts {
+		b := pts[(i+1)%len(pts)]
+		area += a.X*b.Y - b.X*a.Y
+	}
+	k := math.Sqrt(math.Pi / math.Abs(area/2))
+
+	a := so.Rotation * math.Pi / 180
+	sin, cos := math.Sin(a), math.Cos(a)
+	bounds := geom.NilRect()
+	for i, p := range pts {
+		pts[i] = geom.Coord{X: k * (p.X*cos - p.Y*sin), Y: k * (p.X*sin + p.Y*cos)}
+		bounds.ExpandToContainCoord(pts[i])
+	}
+	center := bounds.Center()
+	for i := range pts {
+		pts[i] = pts[i].Minus(center)
+	}
+	return pts
+}
+
+// shapeScaler returns a function that gives the corners, in inches, of the
+// shape that replaces a circle.  Each shape has the same area as its circle
+// but can't reach further from its center, across or down, than maxRadius, so
+// that the biggest shapes still leave at least the margin between neighbouring
+// cells.  Shapes that would are made as big as fits instead.
+func (so *ShapeOptions) shapeScaler(maxRadius float64) func(c Circle) []geom.Coord {
+	outline := so.outline()
+	extent := 0.0
+	for _, p := range outline {
+		extent = math.Max(extent, math.Max(math.Abs(p.X), math.Abs(p.Y)))
+	}
+	return func(c Circle) []geom.Coord {
+		k := math.Min(c.Radius, maxRadius/extent)
+		pts := make([]geom.Coord, len(outline))
+		for i, p := range outline {
+			pts[i] = geom.Coord{X: c.Center.X + k*p.X, Y: c.Center.Y + k*p.Y}
+		}
+		return pts
+	}
+}
+
+// shapePath returns a closed path through pts, which are in inches.
+func (sg *SVGGrid) shapePath(pts []geom.Coord) *svgdata.Path {
+	cmds := []svgdata.PathCommand{}
+	for i, p := range pts {
+		p = sg.scaleCoord(p)
+		cmd := byte('L')
+		if i == 0 {
+			cmd = 'M'
+		}
+		cmds = append(cmds, pathCommand(cmd, p.X, p.Y))
+	}
+	cmds = append(cmds, svgdata.PathCommand{Command: 'Z'})
+	path := svgdata.NewPath()
+	path.SubPaths = []svgdata.SubPath{{Commands: cmds}}
+	return path
+}
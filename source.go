@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseSource returns the procedural GridContent described by spec.  A spec is
+// the kind of source, optionally followed by a colon and its settings, for
+// instance "noise", "radial:cx=0.25,falloff=2" or "expr:sin(x*pi*4)".
+func ParseSource(spec string) (GridContent, error) {
+	kind, rest := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, rest = spec[:i], spec[i+1:]
+	}
+	if kind == "expr" {
+		return NewExprContent(rest)
+	}
+
+	args, err := parseSourceArgs(rest)
+	if err != nil {
+		return nil, fmt.Errorf("source %q: %v", spec, err)
+	}
+	var gc GridContent
+	switch kind {
+	case "linear":
+		gc = &LinearGradient{Angle: args.get("angle", 0)}
+	case "radial":
+		cx, cy := args.get("cx", 0.5), args.get("cy", 0.5)
+		gc = &CircularGradient{
+			CenterX: &cx,
+			CenterY: &cy,
+			Radius:  args.get("radius", 0),
+			Falloff: args.get("falloff", 1),
+		}
+	case "noise":
+		gc = &NoiseContent{
+			Seed:    int64(args.get("seed", 1)),
+			Scale:   args.get("scale", 4),
+			Octaves: int(args.get("octaves", 1)),
+		}
+	case "sine":
+		gc = &SineContent{
+			Freq:  args.get("freq", 6),
+			Angle: args.get("angle", 0),
+			Waves: int(args.get("waves", 1)),
+		}
+	case "checker":
+		gc = &CheckerContent{Count: int(args.get("count", 8))}
+	default:
+		return nil, fmt.Errorf("unknown source %q: use linear, radial, noise, sine, checker or expr", kind)
+	}
+	if err := args.check(); err != nil {
+		return nil, fmt.Errorf("source %q: %v", spec, err)
+	}
+	for _, k := range []string{"falloff", "scale", "octaves", "freq", "waves", "count"} {
+		if f, ok := args.vals[k]; ok && f <= 0 {
+			return nil, fmt.Errorf("source %q: %s must be positive", spec, k)
+		}
+	}
+	if args.vals["radius"] < 0 {
+		return nil, fmt.Errorf("source %q: radius can't be negative", spec)
+	}
+	return gc, nil
+}
+
+// sourceArgs are the key=value settings for a source.
+type sourceArgs struct {
+	vals map[string]float64
+	used map[string]bool
+}
+
+func parseSourceArgs(s string) (*sourceArgs, error) {
+	a := &sourceArgs{vals: map[string]float64{}, used: map[string]bool{}}
+	if s == "" {
+		return a, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected key=value, got %q", kv)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for %s: %q", parts[0], parts[1])
+		}
+		a.vals[strings.TrimSpace(parts[0])] = f
+	}
+	return a, nil
+}
+
+// get returns the setting for key, or def if it wasn't given.
+func (a *sourceArgs) get(key string, def float64) float64 {
+	a.used[key] = true
+	if f, ok := a.vals[key]; ok {
+		return f
+	}
+	return def
+}
+
+// check returns an error if any settings were given that weren't asked for.
+func (a *sourceArgs) check() error {
+	unknown := []string{}
+	for k := range a.vals {
+		if !a.used[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// squareCoord returns the center of cell x, y of a w by h grid measured in
+// lengths of the longer side, so that distances are the same in both
+// directions.
+func squareCoord(x, y, w, h int) (float64, float64) {
+	n := math.Max(float64(w), float64(h))
+	return (float64(x) + 0.5) / n, (float64(y) + 0.5) / n
+}
+
+// LinearGradient goes from light to dark across the canvas.
+type LinearGradient struct {
+	// The direction the gradient darkens towards, in degrees clockwise from
+	// the right.
+	Angle float64
+
+	w, h     int
+	dx, dy   float64
+	min, max float64
+}
+
+func (lg *LinearGradient) SetSize(w, h int) {
+	lg.w, lg.h = w, h
+	a := lg.Angle * math.Pi / 180
+	lg.dx, lg.dy = math.Cos(a), math.Sin(a)
+
+	// The ends of the gradient are at the corners that reach furthest along
+	// it.
+	n := math.Max(float64(w), float64(h))
+	fw, fh := float64(w)/n, float64(h)/n
+	lg.min, lg.max = math.Inf(1), math.Inf(-1)
+	for _, c := range [][2]float64{{0, 0}, {fw, 0}, {0, fh}, {fw, fh}} {
+		d := c[0]*lg.dx + c[1]*lg.dy
+		lg.min = math.Min(lg.min, d)
+		lg.max = math.Max(lg.max, d)
+	}
+}
+
+func (lg *LinearGradient) GetValue(x, y int) float64 {
+	px, py := squareCoord(x, y, lg.w, lg.h)
+	return clampUnit((px*lg.dx + py*lg.dy - lg.min) / (lg.max - lg.min))
+}
+
+// NoiseContent is Perlin noise, a smooth random texture.
+type NoiseContent struct {
+	// The same seed always gives the same noise.
+	Seed int64
+
+	// The number of features across the longer side.
+	Scale float64
+
+	// Octaves above 1 add finer and finer detail.
+	Octaves int
+
+	w, h int
+	perm [512]int
+}
+
+func (nc *NoiseContent) SetSize(w, h int) {
+	nc.w, nc.h = w, h
+	p := rand.New(rand.NewSource(nc.Seed)).Perm(256)
+	for i := range nc.perm {
+		nc.perm[i] = p[i&255]
+	}
+}
+
+func (nc *NoiseContent) GetValue(x, y int) float64 {
+	px, py := squareCoord(x, y, nc.w, nc.h)
+	px, py = px*nc.Scale, py*nc.Scale
+
+	sum, total, amp := 0.0, 0.0, 1.0
+	for o := 0; o < nc.Octaves || o == 0; o++ {
+		sum += amp * nc.noise(px, py)
+		total += amp
+		px, py, amp = px*2, py*2, amp/2
+	}
+	// 2D Perlin noise is within ±√½, so this spreads it over 0 to 1.
+	return clampUnit(0.5 + sum/total/math.Sqrt2)
+}
+
+// noise returns Perlin noise at x, y.
+func (nc *NoiseContent) noise(x, y float64) float64 {
+	fx, fy := math.Floor(x), math.Floor(y)
+	xi, yi := int(fx)&255, int(fy)&255
+	x, y = x-fx, y-fy
+
+	fade := func(t float64) float64 { return t * t * t * (t*(t*6-15) + 10) }
+	lerp := func(t, a, b float64) float64 { return a + t*(b-a) }
+	grad := func(hash int, x, y float64) float64 {
+		switch hash & 7 {
+		case 0:
+			return x + y
+		case 1:
+			return -x + y
+		case 2:
+			return x - y
+		case 3:
+			return -x - y
+		case 4:
+			return x
+		case 5:
+			return -x
+		case 6:
+			return y
+		}
+		return -y
+	}
+
+	u, v := fade(x), fade(y)
+	p := nc.perm
+	aa, ab := p[p[xi]+yi], p[p[xi]+yi+1]
+	ba, bb := p[p[xi+1]+yi], p[p[xi+1]+yi+1]
+	return lerp(v,
+		lerp(u, grad(aa, x, y), grad(ba, x-1, y)),
+		lerp(u, grad(ab, x, y-1), grad(bb, x-1, y-1)))
+}
+
+// SineContent is a set of sine waves.  More than one wave gives interference
+// patterns.
+type SineContent struct {
+	// The number of cycles across the longer side.
+	Freq float64
+
+	// The direction of the first wave, in degrees clockwise from the right.
+	Angle float64
+
+	// The number of waves, spread evenly around a half turn.
+	Waves int
+
+	w, h int
+}
+
+func (sc *SineContent) SetSize(w, h int) {
+	sc.w, sc.h = w, h
+}
+
+func (sc *SineContent) GetValue(x, y int) float64 {
+	px, py := squareCoord(x, y, sc.w, sc.h)
+	n := sc.Waves
+	if n < 1 {
+		n = 1
+	}
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		a := sc.Angle*math.Pi/180 + float64(i)*math.Pi/float64(n)
+		sum += math.Sin(2 * math.Pi * sc.Freq * (px*math.Cos(a) + py*math.Sin(a)))
+	}
+	return clampUnit(0.5 + sum/float64(2*n))
+}
+
+// CheckerContent is a checkerboard of fully dark and fully light squares.
+type CheckerContent struct {
+	// The number of squares across the longer side.
+	Count int
+
+	w, h int
+}
+
+func (cc *CheckerContent) SetSize(w, h int) {
+	cc.w, cc.h = w, h
+}
+
+func (cc *CheckerContent) GetValue(x, y int) float64 {
+	px, py := squareCoord(x, y, cc.w, cc.h)
+	n := float64(cc.Count)
+	if int(math.Floor(px*n)+math.Floor(py*n))%2 == 0 {
+		return 1
+	}
+	return 0
+}
+
+// ExprContent takes its values from an expression.  The expression can use x
+// and y, which go from 0 to 1 across the canvas, r, the distance from the
+// center with 1 at the middle of the longer side, and a, the angle around the
+// center in radians.  Results are clamped to 0 to 1.
+type ExprContent struct {
+	e    expr
+	w, h int
+}
+
+// NewExprContent compiles the expression s.
+func NewExprContent(s string) (*ExprContent, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("expr source needs an expression, as in expr:x*y")
+	}
+	e, err := parseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	return &ExprContent{e: e}, nil
+}
+
+func (ec *ExprContent) SetSize(w, h int) {
+	ec.w, ec.h = w, h
+}
+
+func (ec *ExprContent) GetValue(x, y int) float64 {
+	px, py := squareCoord(x, y, ec.w, ec.h)
+	n := math.Max(float64(ec.w), float64(ec.h))
+	dx, dy := px-float64(ec.w)/n/2, py-float64(ec.h)/n/2
+	v := &exprVars{
+		x: (float64(x) + 0.5) / float64(ec.w),
+		y: (float64(y) + 0.5) / float64(ec.h),
+		r: 2 * math.Hypot(dx, dy),
+		a: math.Atan2(dy, dx),
+	}
+	f := ec.e(v)
+	if math.IsNaN(f) {
+		return 0
+	}
+	return clampUnit(f)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jbeda/geom"
+)
+
+// Stats summarizes a layout.  Lengths are in inches.
+type Stats struct {
+	Circles int `json:"circles"`
+	Skipped int `json:"skipped"`
+
+	// The number of circles in each cooling group.
+	Groups []int `json:"groups"`
+
+	MinRadius float64 `json:"minRadius"`
+	MaxRadius float64 `json:"maxRadius"`
+
+	// The total length of everything that is cut, as it is cut.  This is a
+	// rough guide to how long the job will take.
+	CutLength float64 `json:"cutLength"`
+
+	BoardWidth   float64 `json:"boardWidth"`
+	BoardHeight  float64 `json:"boardHeight"`
+	CanvasWidth  float64 `json:"canvasWidth"`
+	CanvasHeight float64 `json:"canvasHeight"`
+}
+
+// NewStats returns the stats for circles laid out by sg.
+func NewStats(sg *SVGGrid, circles []Circle) *Stats {
+	st := &Stats{
+		Circles:      len(circles),
+		Skipped:      sg.Cells() - len(circles),
+		Groups:       make([]int, numGroups),
+		BoardWidth:   sg.p.BoardWidth,
+		BoardHeight:  sg.p.BoardHeight,
+		CanvasWidth:  sg.p.CanvasWidth,
+		CanvasHeight: sg.p.CanvasHeight,
+	}
+	st.CutLength = sg.cutLength(circles)
+	for i, c := range circles {
+		st.Groups[c.Group]++
+		if i == 0 || c.Radius < st.MinRadius {
+			st.MinRadius = c.Radius
+		}
+		if c.Radius > st.MaxRadius {
+			st.MaxRadius = c.Radius
+		}
+	}
+	return st
+}
+
+// cutLength returns the total length, in inches, around the marks sg cuts for
+// circles, whether they are circles, shapes, motifs or flow marks.  Engraved
+// marks aren't cut and aren't counted.
+func (sg *SVGGrid) cutLength(circles []Circle) float64 {
+	var shape func(c Circle) []geom.Coord
+	if sg.Shape != nil {
+		shape = sg.Shape.shapeScaler(sg.p.MaxRadius())
+	}
+	var motif float64
+	if sg.Motif != nil {
+		motif = sg.Motif.length()
+	}
+
+	l := 0.0
+	for _, c := range circles {
+		if sg.Engrave.engraves(c.Radius) {
+			continue
+		}
+		switch {
+		case sg.Flow != nil:
+			l += sg.Flow.perimeter(c)
+		case sg.Motif != nil:
+			l += motif * c.Radius
+		case shape != nil:
+			l += polylineLength(shape(c), true)
+		default:
+			l += 2 * math.Pi * c.Radius
+		}
+	}
+	return l
+}
+
+// polylineLength returns the length of the lines through pts, back to the
+// first if closed.
+func polylineLength(pts []geom.Coord, closed bool) float64 {
+	l := 0.0
+	for i := 1; i < len(pts); i++ {
+		l += pts[i].DistanceFrom(pts[i-1])
+	}
+	if closed && len(pts) > 1 {
+		l += pts[0].DistanceFrom(pts[len(pts)-1])
+	}
+	return l
+}
+
+func (st *Stats) String() string {
+	return fmt.Sprintf("%d circles, %d skipped, %.1fin of cutting", st.Circles, st.Skipped, st.CutLength)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jbeda/geom"
+)
+
+// strokeGlyph is a character of a single stroke font, in the style of the
+// Hershey fonts.  It is drawn as lines rather than filled, so it can be
+// engraved or scored in one pass.  Coordinates are in units where capitals are
+// 10 high, with y going up from the baseline.
+type strokeGlyph struct {
+	width   float64
+	strokes [][]geom.Coord
+}
+
+// strokeCapHeight is the height of capitals in font units.
+const strokeCapHeight = 10.0
+
+// strokeSpacing is the gap between characters in font units.
+const strokeSpacing = 2.5
+
+// strokeLowerScale is how big lowercase letters are compared to capitals.  The
+// font only has capitals so lowercase is drawn as small capitals.
+const strokeLowerScale = 0.7
+
+// strokeFontSource describes each character.  Each one is its width followed
+// by drawing commands: "Mx,y" starts a stroke, "Lx,y" draws a line to a
+// point, "Acx,cy,rx,ry,from,to" draws an elliptical arc between two angles in
+// degrees (counterclockwise when to is bigger), joining it on to the current
+// stroke, and "|" ends the current stroke.
+var strokeFontSource = map[rune]string{
+	' ': "5",
+	'A': "8 M0,0 L4,10 L8,0 | M1.4,3.5 L6.6,3.5",
+	'B': "7 M0,0 L0,10 L4.5,10 A4.5,7.5,2.5,2.5,90,-90 L0,5 | M4.5,5 A4.5,2.5,2.5,2.5,90,-90 L0,0",
+	'C': "9 A4.5,5,4.5,5,45,315",
+	'D': "8 M0,0 L0,10 L3,10 A3,5,5,5,90,-90 L0,0",
+	'E': "7 M7,10 L0,10 L0,0 L7,0 | M0,5 L5,5",
+	'F': "7 M7,10 L0,10 L0,0 | M0,5 L5,5",
+	'G': "9 A4.5,5,4.5,5,50,360 L5,5",
+	'H': "8 M0,0 L0,10 | M8,0 L8,10 | M0,5 L8,5",
+	'I': "0 M0,0 L0,10",
+	'J': "6 M6,10 L6,3 A3,3,3,3,0,-180",
+	'K': "7 M0,0 L0,10 | M7,10 L0,3.5 | M2.3,5.6 L7,0",
+	'L': "6 M0,10 L0,0 L6,0",
+	'M': "9 M0,0 L0,10 L4.5,2 L9,10 L9,0",
+	'N': "8 M0,0 L0,10 L8,0 L8,10",
+	'O': "9 A4.5,5,4.5,5,0,360",
+	'P': "7 M0,0 L0,10 L4.5,10 A4.5,7.5,2.5,2.5,90,-90 L0,5",
+	'Q': "9 A4.5,5,4.5,5,0,360 | M5.5,2.5 L9,-0.5",
+	'R': "7 M0,0 L0,10 L4.5,10 A4.5,7.5,2.5,2.5,90,-90 L0,5 | M4,5 L7,0",
+	'S': "7 A3.5,7.5,3.25,2.5,20,270 A3.5,2.5,3.5,2.5,90,-160",
+	'T': "8 M0,10 L8,10 | M4,10 L4,0",
+	'U': "8 M0,10 L0,4 A4,4,4,4,180,360 L8,10",
+	'V': "8 M0,10 L4,0 L8,10",
+	'W': "11 M0,10 L2.5,0 L5.5,8 L8.5,0 L11,10",
+	'X': "8 M0,10 L8,0 | M0,0 L8,10",
+	'Y': "8 M0,10 L4,5 L8,10 | M4,5 L4,0",
+	'Z': "8 M0,10 L8,10 L0,0 L8,0",
+
+	'0': "7 A3.5,5,3.5,5,0,360",
+	'1': "5 M1,8 L3.5,10 L3.5,0",
+	'2': "7 A3.5,6.5,3.5,3.5,160,-30 L0,0 L7,0",
+	'3': "7 A3.5,7.5,3,2.5,150,-90 A3.5,2.5,3.5,2.5,90,-150",
+	'4': "7.5 M5.5,0 L5.5,10 L0,3 L7.5,3",
+	'5': "7 M6.5,10 L1,10 L0.5,5.5 A3.5,3.2,3.3,3.2,150,-150",
+	'6': "7 A3.5,3.2,3.5,3.2,0,360 | M0,3.2 A6,3.2,6,6.8,180,100",
+	'7': "7 M0,10 L7,10 L2.5,0",
+	'8': "7 A3.5,7.6,3,2.4,-90,270 | A3.5,2.6,3.5,2.6,90,450",
+	'9': "7 A3.5,6.8,3.5,3.2,0,360 | M7,6.8 A1,6.8,6,6.8,0,-80",
+
+	'.':  "1 A0.5,0.5,0.5,0.5,0,360",
+	',':  "1 M0.8,0.6 L0.8,0 L0,-1.5",
+	':':  "1 A0.5,0.5,0.5,0.5,0,360 | A0.5,6.5,0.5,0.5,0,360",
+	'-':  "5 M0,5 L5,5",
+	'+':  "6 M3,2 L3,8 | M0,5 L6,5",
+	'/':  "6 M0,-1 L6,11",
+	'\'': "1 M0.5,10 L0.5,7",
+	'"':  "3 M0.5,10 L0.5,7 | M2.5,10 L2.5,7",
+	'!':  "1 M0.5,10 L0.5,3 | A0.5,0.5,0.5,0.5,0,360",
+	'?':  "6 A3,7.5,3,2.5,160,-90 L3,3 | A3,0.5,0.5,0.5,0,360",
+	'(':  "3 A4.5,5,4.5,6,115,245",
+	')':  "3 A-1.5,5,4.5,6,65,-65",
+	'©':  "10 A5,5,5,5,0,360 | A5,5,2.5,2.5,40,320",
+}
+
+// strokeFont is strokeFontSource, parsed.
+var strokeFont = map[rune]*strokeGlyph{}
+
+func init() {
+	for r, src := range strokeFontSource {
+		g, err := parseStrokeGlyph(src)
+		if err != nil {
+			panic(fmt.Sprintf("stroke font %q: %v", r, err))
+		}
+		strokeFont[r] = g
+	}
+}
+
+func parseStrokeGlyph(src string) (*strokeGlyph, error) {
+	tokens := strings.Fields(src)
+	w, err := strconv.ParseFloat(tokens[0], 64)
+	if err != nil {
+		return nil, err
+	}
+	g := &strokeGlyph{width: w}
+
+	var stroke []geom.Coord
+	end := func() {
+		if len(stroke) > 1 {
+			g.strokes = append(g.strokes, stroke)
+		}
+		stroke = nil
+	}
+	add := func(c geom.Coord) {
+		if n := len(stroke); n > 0 && math.Abs(stroke[n-1].X-c.X) < 1e-9 && math.Abs(stroke[n-1].Y-c.Y) < 1e-9 {
+			return
+		}
+		stroke = append(stroke, c)
+	}
+
+	for _, t := range tokens[1:] {
+		if t == "|" {
+			end()
+			continue
+		}
+		var args []float64
+		for _, a := range strings.Split(t[1:], ",") {
+			f, err := strconv.ParseFloat(a, 64)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, f)
+		}
+		switch {
+		case t[0] == 'M' && len(args) == 2:
+			end()
+			add(geom.Coord{X: args[0], Y: args[1]})
+		case t[0] == 'L' && len(args) == 2:
+			add(geom.Coord{X: args[0], Y: args[1]})
+		case t[0] == 'A' && len(args) == 6:
+			from, to := args[4]*math.Pi/180, args[5]*math.Pi/180
+			steps := int(math.Ceil(math.Abs(to-from) / (math.Pi / 12)))
+			for s := 0; s <= steps; s++ {
+				a := from + (to-from)*float64(s)/float64(steps)
+				add(geom.Coord{X: args[0] + args[2]*math.Cos(a), Y: args[1] + args[3]*math.Sin(a)})
+			}
+		default:
+			return nil, fmt.Errorf("bad command %q", t)
+		}
+	}
+	end()
+	return g, nil
+}
+
+// strokeGlyphFor returns the glyph for r and how much it is scaled by, or nil
+// if the font doesn't have it.
+func strokeGlyphFor(r rune) (*strokeGlyph, float64) {
+	if g := strokeFont[r]; g != nil {
+		return g, 1
+	}
+	if u := []rune(strings.ToUpper(string(r))); len(u) == 1 && u[0] != r {
+		if g := strokeFont[u[0]]; g != nil {
+			return g, strokeLowerScale
+		}
+	}
+	return nil, 0
+}
+
+// checkStrokeText returns an error if s uses characters the font doesn't
+// have.
+func checkStrokeText(s string) error {
+	for _, r := range s {
+		if g, _ := strokeGlyphFor(r); g == nil {
+			return fmt.Errorf("the caption font has no %q", r)
+		}
+	}
+	return nil
+}
+
+// strokeTextWidth returns the width of s, in capital heights.
+func strokeTextWidth(s string) float64 {
+	w := 0.0
+	for i, r := range []rune(s) {
+		g, scale := strokeGlyphFor(r)
+		if g == nil {
+			continue
+		}
+		if i > 0 {
+			w += strokeSpacing
+		}
+		w += g.width * scale
+	}
+	return w / strokeCapHeight
+}
+
+// strokeText returns the strokes for s with the left end of its baseline at
+// origin and capitals size high.  The y axis goes down, as in SVG.
+func strokeText(s string, origin geom.Coord, size float64) [][]geom.Coord {
+	k := size / strokeCapHeight
+	strokes := [][]geom.Coord{}
+	x := 0.0
+	for i, r := range []rune(s) {
+		g, scale := strokeGlyphFor(r)
+		if g == nil {
+			continue
+		}
+		if i > 0 {
+			x += strokeSpacing
+		}
+		for _, st := range g.strokes {
+			out := make([]geom.Coord, len(st))
+			for j, c := range st {
+				out[j] = geom.Coord{
+					X: origin.X + (x+c.X*scale)*k,
+					Y: origin.Y - c.Y*scale*k,
+				}
+			}
+			strokes = append(strokes, out)
+		}
+		x += g.width * scale
+	}
+	return strokes
+}
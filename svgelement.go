@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// svgElement is a plain SVG element for the elements that svgdata doesn't have
+// a constructor for, such as <title> or <metadata>.  It can only be written,
+// not read.
+type svgElement struct {
+	name     string
+	attrs    svgdata.AttrMap
+	children []svgdata.Node
+	text     string
+}
+
+var _ svgdata.Node = (*svgElement)(nil)
+
+func newSVGElement(name string) *svgElement {
+	return &svgElement{name: name, attrs: svgdata.AttrMap{}}
+}
+
+func newTextElement(name, text string) *svgElement {
+	e := newSVGElement(name)
+	e.text = text
+	return e
+}
+
+func (e *svgElement) Name() string              { return e.name }
+func (e *svgElement) Attrs() svgdata.AttrMap    { return e.attrs }
+func (e *svgElement) Children() *[]svgdata.Node { return &e.children }
+func (e *svgElement) AddChild(n svgdata.Node)   { e.children = append(e.children, n) }
+func (e *svgElement) GetText() string           { return e.text }
+func (e *svgElement) SetText(t string)          { e.text = t }
+
+func (e *svgElement) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	return fmt.Errorf("svgElement can't be unmarshalled")
+}
+
+func (e *svgElement) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	se := svgdata.MakeStartElement(e.name, e.attrs)
+	if err := enc.EncodeToken(se); err != nil {
+		return err
+	}
+	for _, c := range e.children {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	if len(e.children) == 0 && e.text != "" {
+		if err := enc.EncodeToken(xml.CharData(e.text)); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(se.End())
+}
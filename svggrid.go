@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -12,17 +13,64 @@ import (
 )
 
 type SVGGrid struct {
+	p              Params
 	xNum, yNum     int
 	xSpace, ySpace float64
+
+	// Maps values to radii.
+	Curve ToneCurve
+
+	// How partly covered cells are handled.  This is only used for content
+	// that implements CoverageContent.
+	Alpha *AlphaOptions
+
+	// If set, every radius is snapped to one of a fixed set of sizes.
+	Quantizer *Quantizer
+
+	// The units ("in" or "mm") used for the width and height of the SVG.
+	Units string
+
+	// If set, circles are written as paths.
+	Paths *PathOptions
+
+	// If set, cells are cut as polygons instead of circles.
+	Shape *ShapeOptions
+
+	// If set, cells are cut as this drawing instead of circles.
+	Motif *Motif
+
+	// If set, circles are stretched along the edges of the content.
+	Flow *FlowOptions
+
+	// If set, larger circles are written as paths with uncut tabs.
+	Tabs *TabOptions
+
+	// If set, some or all of the circles are engraved instead of cut.
+	Engrave *EngraveOptions
+
+	// Text engraved around the canvas.
+	Captions []Caption
+
+	// If set, this is recorded in the SVG.
+	Meta *Metadata
+
+	// Set while writing one sheet of a stacked relief.
+	sheet *sheetInfo
+
+	// Set while writing one tile of a tiled canvas.
+	tile *tileInfo
+
+	// Set while writing a board of nested pieces.
+	nest *nestInfo
 }
 
-func NewSVGGrid() *SVGGrid {
-	sg := &SVGGrid{}
+func NewSVGGrid(p Params) *SVGGrid {
+	sg := &SVGGrid{p: p}
 
-	sg.xNum = int(math.Floor(canvasInsideWidth / cSpace))
-	sg.xSpace = canvasInsideWidth / float64(sg.xNum)
-	sg.yNum = int(math.Floor(canvasInsideHeight / cSpace))
-	sg.ySpace = canvasInsideHeight / float64(sg.yNum)
+	sg.xNum = int(math.Floor(p.canvasInsideWidth() / p.Space))
+	sg.xSpace = p.canvasInsideWidth() / float64(sg.xNum)
+	sg.yNum = int(math.Floor(p.canvasInsideHeight() / p.Space))
+	sg.ySpace = p.canvasInsideHeight() / float64(sg.yNum)
 
 	return sg
 }
@@ -31,12 +79,23 @@ func (sg *SVGGrid) createStyleElement() svgdata.Node {
 	style := svgdata.NewStyle()
 	style.Attrs()["type"] = "text/css"
 
-	colors := initColors(4)
+	colors := initColors(numGroups)
 
 	b := bytes.Buffer{}
-	b.WriteString(fmt.Sprintf(".border{fill:none;stroke:red;stroke-width:%g;}\n", scaleValue(strokeWidth)))
-	for i := 0; i < 4; i++ {
-		b.WriteString(fmt.Sprintf(".c%d{fill:none;stroke:%s;stroke-width:%g;}\n", i, colors[i], scaleValue(strokeWidth)))
+	b.WriteString(fmt.Sprintf(".border{fill:none;stroke:red;stroke-width:%g;}\n", sg.scaleValue(sg.p.StrokeWidth)))
+	for i := 0; i < numGroups; i++ {
+		b.WriteString(fmt.Sprintf(".c%d{fill:none;stroke:%s;stroke-width:%g;}\n", i, colors[i], sg.scaleValue(sg.p.StrokeWidth)))
+	}
+	if sg.sheet != nil {
+		b.WriteString(fmt.Sprintf(".label{fill:#0000ff;stroke:none;font-family:sans-serif;font-size:%gpx;}\n", sg.scaleValue(sg.p.CanvasMargin/2)))
+	}
+	if len(sg.Captions) > 0 {
+		b.WriteString(fmt.Sprintf(".caption{fill:none;stroke:#ff00ff;stroke-width:%g;stroke-linecap:round;stroke-linejoin:round;}\n", sg.scaleValue(sg.p.StrokeWidth)))
+	}
+	if sg.Engrave != nil {
+		for i, c := range initEngraveColors(numGroups) {
+			b.WriteString(fmt.Sprintf(".e%d{fill:%s;stroke:none;}\n", i, c))
+		}
 	}
 
 	style.SetText(b.String())
@@ -45,10 +104,16 @@ func (sg *SVGGrid) createStyleElement() svgdata.Node {
 
 func (sg *SVGGrid) CreateRoot() *svgdata.Root {
 	r := svgdata.CreateRoot()
-	r.Attrs()["viewBox"] = fmt.Sprintf("0 0 %g %g", scaleValue(boardWidth), scaleValue(boardHeight))
+	r.Attrs()["viewBox"] = fmt.Sprintf("0 0 %g %g", sg.scaleValue(sg.p.BoardWidth), sg.scaleValue(sg.p.BoardHeight))
 	r.Attrs()["version"] = "1.1"
-	//r.Attrs()["width"] = fmt.Sprintf("%gin", boardWidth)
-	//r.Attrs()["height"] = fmt.Sprintf("%gin", boardHeight)
+	if sg.Units == "mm" {
+		r.Attrs()["width"] = fmt.Sprintf("%gmm", sg.p.BoardWidth*mmPerInch)
+		r.Attrs()["height"] = fmt.Sprintf("%gmm", sg.p.BoardHeight*mmPerInch)
+	} else {
+		r.Attrs()["width"] = fmt.Sprintf("%gin", sg.p.BoardWidth)
+		r.Attrs()["height"] = fmt.Sprintf("%gin", sg.p.BoardHeight)
+	}
+	r.Attrs()["xmlns:inkscape"] = inkscapeNs
 	r.Attrs()["x"] = "0px"
 	r.Attrs()["y"] = "0px"
 	r.Attrs()["style"] = fmt.Sprintf("enable-background:new %s;", r.Attrs()["viewBox"])
@@ -58,43 +123,315 @@ func (sg *SVGGrid) CreateRoot() *svgdata.Root {
 	return r
 }
 
-func (sg *SVGGrid) RenderGrid(gc GridContent, outputPrefix string) {
-	gc.SetSize(sg.xNum, sg.yNum)
+// canvasOffset returns the position of the top left of the canvas, which is
+// centered on the board.
+func (sg *SVGGrid) canvasOffset() (float64, float64) {
+	return (sg.p.BoardWidth - sg.p.CanvasWidth) / 2.0, (sg.p.BoardHeight - sg.p.CanvasHeight) / 2.0
+}
 
-	xOffset := (boardWidth - canvasWidth) / 2.0
-	yOffset := (boardHeight - canvasHeight) / 2.0
+// Cells returns the number of cells in the grid.
+func (sg *SVGGrid) Cells() int {
+	return sg.xNum * sg.yNum
+}
 
-	r := sg.CreateRoot()
+// radii returns the radius, in inches, of the circle for each cell indexed as
+// [x][y].  Cells without a circle are set to noCircle.
+func (sg *SVGGrid) radii(ctx context.Context, gc GridContent) ([][]float64, error) {
+	cc, hasCoverage := gc.(CoverageContent)
 
-	for xSkip := 0; xSkip < 2; xSkip++ {
-		for ySkip := 0; ySkip < 2; ySkip++ {
+	r := make([][]float64, sg.xNum)
+	for x := range r {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		r[x] = make([]float64, sg.yNum)
+		for y := range r[x] {
+			r[x][y] = sg.Curve.Radius(gc.GetValue(x, y), sg.p.MinRadius, sg.p.MaxRadius())
+			if hasCoverage {
+				r[x][y] = sg.Alpha.apply(r[x][y], cc.GetCoverage(x, y))
+			}
+		}
+	}
 
-			if xSkip == 1 && ySkip == 1 {
-				outline := svgdata.NewRectXYWH(scaleValue(xOffset), scaleValue(yOffset), scaleValue(canvasWidth), scaleValue(canvasHeight))
-				r.AddChild(outline)
-				outline.Attrs()["class"] = "border"
+	if sg.Quantizer != nil {
+		if err := sg.Quantizer.Quantize(ctx, r, sg.p.MaxRadius()); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// numGroups is the number of cooling groups the circles are split into.  Each
+// group is cut as a separate pass so that neighbouring circles get a chance to
+// cool.
+const numGroups = 4
+
+// Circle is a single circle in a layout.  Positions and sizes are in inches on
+// the board.
+type Circle struct {
+	Center geom.Coord
+	Radius float64
+	// The cooling group (cut pass) the circle belongs to.
+	Group int
+	// The value of the cell the circle is for.
+	Value float64
+	// When the circle is stretched to follow the flow of the content, the
+	// angle of its long side in radians clockwise from across, and how many
+	// times longer than it is wide it is.
+	Angle, Aspect float64
+}
+
+// Layout sizes gc to the grid and returns the circles to cut, in cut order.
+func (sg *SVGGrid) Layout(gc GridContent) []Circle {
+	circles, _ := sg.LayoutContext(context.Background(), gc)
+	return circles
+}
+
+// LayoutContext is Layout but stops early, returning ctx's error, once ctx is
+// done.
+func (sg *SVGGrid) LayoutContext(ctx context.Context, gc GridContent) ([]Circle, error) {
+	if cs, ok := gc.(contextSizer); ok {
+		if err := cs.SetSizeContext(ctx, sg.xNum, sg.yNum); err != nil {
+			return nil, err
+		}
+	} else {
+		gc.SetSize(sg.xNum, sg.yNum)
+	}
+	radii, err := sg.radii(ctx, gc)
+	if err != nil {
+		return nil, err
+	}
+
+	var angle, aspect [][]float64
+	if sg.Flow != nil {
+		values := make([][]float64, sg.xNum)
+		for x := range values {
+			values[x] = make([]float64, sg.yNum)
+			for y := range values[x] {
+				values[x][y] = gc.GetValue(x, y)
 			}
+		}
+		angle, aspect, err = sg.Flow.orient(ctx, values)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			g := svgdata.NewGroup()
-			r.AddChild(g)
+	xOffset, yOffset := sg.canvasOffset()
 
+	circles := []Circle{}
+	for xSkip := 0; xSkip < 2; xSkip++ {
+		for ySkip := 0; ySkip < 2; ySkip++ {
 			for x := 0 + xSkip; x < sg.xNum; x += 2 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
 				for y := 0 + ySkip; y < sg.yNum; y += 2 {
-					c := scaleCoord(geom.Coord{
-						xOffset + canvasMargin + cSpace/2 + float64(x)*sg.xSpace,
-						yOffset + canvasMargin + cSpace/2 + float64(y)*sg.ySpace,
-					})
-					rRaw := gc.GetValue(x, y)
-					rad := scaleValue(scaleToRange(rRaw, 1.0, cMinRadius, cMaxRadius))
-					circle := svgdata.NewCircle(c, rad)
-					circle.Attrs()["class"] = fmt.Sprintf("c%d", 2*xSkip+ySkip)
-					g.AddChild(circle)
+					if radii[x][y] <= 0 {
+						continue
+					}
+					c := Circle{
+						Center: geom.Coord{
+							X: xOffset + sg.p.CanvasMargin + sg.p.Space/2 + float64(x)*sg.xSpace,
+							Y: yOffset + sg.p.CanvasMargin + sg.p.Space/2 + float64(y)*sg.ySpace,
+						},
+						Radius: radii[x][y],
+						Group:  2*xSkip + ySkip,
+						Value:  gc.GetValue(x, y),
+					}
+					if sg.Flow != nil {
+						c.Angle = angle[x][y]
+						c.Aspect = sg.Flow.fit(c.Radius, c.Angle, aspect[x][y], sg.p.MaxRadius())
+					}
+					circles = append(circles, c)
 				}
 			}
 		}
 	}
+	return circles, nil
+}
+
+// RenderGrid lays out gc and writes it to <outputPrefix>.svg.  The circles are
+// returned so they can be written out in other formats.
+func (sg *SVGGrid) RenderGrid(gc GridContent, outputPrefix string) []Circle {
+	circles := sg.Layout(gc)
+	sg.WriteSVG(circles, outputPrefix)
+	return circles
+}
+
+// newLayer creates a group that shows up as a named layer in Inkscape and as a
+// named operation in cutter software.
+func newLayer(id, label string) svgdata.Node {
+	g := svgdata.NewGroup()
+	g.Attrs()["id"] = id
+	g.Attrs()["inkscape:groupmode"] = "layer"
+	g.Attrs()["inkscape:label"] = label
+	return g
+}
+
+// addDescription adds a <title>, <desc> and, if there is any, <metadata> to r.
+func (sg *SVGGrid) addDescription(r *svgdata.Root, circles []Circle, outputPrefix string) {
+	r.AddChild(newTextElement("title", fmt.Sprintf("circle-art: %s", outputPrefix)))
+
+	size := fmt.Sprintf("%gin x %gin", sg.p.BoardWidth, sg.p.BoardHeight)
+	if sg.Units == "mm" {
+		size = fmt.Sprintf("%gmm x %gmm", sg.p.BoardWidth*mmPerInch, sg.p.BoardHeight*mmPerInch)
+	}
+	desc := fmt.Sprintf("%d circles in %d passes on a %s board", len(circles), numGroups, size)
+	if sg.sheet != nil {
+		desc = fmt.Sprintf("Sheet %d of %d: %s", sg.sheet.number, sg.sheet.count, desc)
+	}
+	if sg.nest != nil {
+		desc = fmt.Sprintf("%d nested pieces: %s", len(sg.nest.places), desc)
+	}
+	if sg.tile != nil {
+		tr := sg.tile.rect
+		desc = fmt.Sprintf("Tile %s, covering (%gin, %gin) to (%gin, %gin) of the canvas: %s", sg.tile.name, tr.Min.X, tr.Min.Y, tr.Max.X, tr.Max.Y, desc)
+	}
+	if sg.Meta != nil && sg.Meta.Job != nil && sg.Meta.Job.Input != "" {
+		desc += fmt.Sprintf(" from %s", sg.Meta.Job.Input)
+	}
+	r.AddChild(newTextElement("desc", desc))
+
+	if sg.Meta != nil {
+		r.AddChild(newTextElement("metadata", sg.Meta.String()))
+	}
+}
+
+// addSheetLayers adds the dowel holes and the label for a sheet of a stacked
+// relief.  The label is engraved in the bottom margin.
+func (sg *SVGGrid) addSheetLayers(r *svgdata.Root) {
+	dowels := newLayer("dowels", "Dowels")
+	r.AddChild(dowels)
+	for _, c := range sg.sheet.dowels {
+		circle := svgdata.NewCircle(sg.scaleCoord(c.Center), sg.scaleValue(c.Radius))
+		circle.Attrs()["class"] = "border"
+		dowels.AddChild(circle)
+	}
+
+	xOffset, yOffset := sg.canvasOffset()
+	label := newLayer("label", "Label")
+	r.AddChild(label)
+	text := newTextElement("text", sg.sheet.label)
+	text.Attrs()["class"] = "label"
+	text.Attrs()["x"] = fmt.Sprintf("%g", sg.scaleValue(xOffset+sg.p.CanvasMargin))
+	text.Attrs()["y"] = fmt.Sprintf("%g", sg.scaleValue(yOffset+sg.p.CanvasHeight-sg.p.CanvasMargin/3))
+	label.AddChild(text)
+}
+
+// borderNodes returns the outlines that are cut around the canvas.
+func (sg *SVGGrid) borderNodes() []svgdata.Node {
+	switch {
+	case sg.tile != nil:
+		return []svgdata.Node{sg.tileOutline()}
+	case sg.nest != nil:
+		return sg.nestOutlines()
+	}
+	xOffset, yOffset := sg.canvasOffset()
+	return []svgdata.Node{svgdata.NewRectXYWH(sg.scaleValue(xOffset), sg.scaleValue(yOffset), sg.scaleValue(sg.p.CanvasWidth), sg.scaleValue(sg.p.CanvasHeight))}
+}
+
+// WriteSVG writes circles to <outputPrefix>.svg.  See MarshalSVG.
+func (sg *SVGGrid) WriteSVG(circles []Circle, outputPrefix string) {
+	ioutil.WriteFile(fmt.Sprintf("%s.svg", outputPrefix), sg.MarshalSVG(circles, outputPrefix), 0644)
+}
+
+// MarshalSVG returns the SVG for circles with one layer per cooling group.
+// Captions and engraved circles come first, in layers of their own, so that
+// they are done before anything is cut loose.  name is used for the title.
+func (sg *SVGGrid) MarshalSVG(circles []Circle, name string) []byte {
+	r := sg.CreateRoot()
+	sg.addDescription(r, circles, name)
+
+	if sg.Motif != nil && !sg.Motif.inline {
+		sg.addMotifSymbol(r)
+	}
+	if len(sg.Captions) > 0 {
+		sg.addCaptionLayer(r)
+	}
+
+	var shape func(c Circle) []geom.Coord
+	if sg.Shape != nil {
+		shape = sg.Shape.shapeScaler(sg.p.MaxRadius())
+	}
+
+	if sg.Engrave != nil {
+		for group := 0; group < numGroups; group++ {
+			g := newLayer(fmt.Sprintf("engrave%d", group+1), fmt.Sprintf("Engrave %d of %d", group+1, numGroups))
+			for _, c := range circles {
+				if c.Group != group || !sg.Engrave.engraves(c.Radius) {
+					continue
+				}
+				var circle svgdata.Node
+				if sg.Flow != nil {
+					circle = sg.flowPath(c)
+				} else if sg.Motif != nil {
+					circle = sg.motifNode(c)
+				} else if shape != nil {
+					circle = sg.shapePath(shape(c))
+				} else {
+					circle = svgdata.NewCircle(sg.scaleCoord(c.Center), sg.scaleValue(c.Radius))
+				}
+				circle.Attrs()["class"] = fmt.Sprintf("e%d", group)
+				g.AddChild(circle)
+			}
+			if len(*g.Children()) > 0 {
+				r.AddChild(g)
+			}
+		}
+	}
+
+	// Tabs are written as paths even if circles otherwise aren't.
+	paths := sg.Paths
+	if paths == nil {
+		paths = &PathOptions{}
+	}
+	startAngle := paths.startAngles()
+
+	for group := 0; group < numGroups; group++ {
+		if group == numGroups-1 {
+			if sg.sheet != nil {
+				sg.addSheetLayers(r)
+			}
+			border := newLayer("border", "Border")
+			r.AddChild(border)
+			for _, outline := range sg.borderNodes() {
+				outline.Attrs()["class"] = "border"
+				border.AddChild(outline)
+			}
+		}
+
+		g := newLayer(fmt.Sprintf("pass%d", group+1), fmt.Sprintf("Pass %d of %d", group+1, numGroups))
+
+		for _, c := range circles {
+			if c.Group != group || sg.Engrave.engraves(c.Radius) {
+				continue
+			}
+			var circle svgdata.Node
+			if sg.Flow != nil {
+				circle = sg.flowPath(c)
+			} else if sg.Motif != nil {
+				circle = sg.motifNode(c)
+			} else if shape != nil {
+				circle = sg.shapePath(shape(c))
+			} else if gap, ok := sg.Tabs.gap(c.Radius); ok {
+				circle = paths.tabbedPath(sg.scaleCoord(c.Center), sg.scaleValue(c.Radius), startAngle(), sg.Tabs.count(), gap)
+			} else if sg.Paths != nil {
+				circle = paths.circlePath(sg.scaleCoord(c.Center), sg.scaleValue(c.Radius), startAngle())
+			} else {
+				circle = svgdata.NewCircle(sg.scaleCoord(c.Center), sg.scaleValue(c.Radius))
+			}
+			circle.Attrs()["class"] = fmt.Sprintf("c%d", group)
+			g.AddChild(circle)
+		}
+
+		// When engraving, passes with nothing left to cut are left out.
+		if sg.Engrave == nil || len(*g.Children()) > 0 {
+			r.AddChild(g)
+		}
+	}
 
-	// Write out the SVG file
 	d, _ := svgdata.Marshal(r, true)
-	ioutil.WriteFile(fmt.Sprintf("%s.svg", outputPrefix), d, 0644)
+	return d
 }
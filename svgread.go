@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// ReadSVG reads an SVG file.  Files from other programs are cleaned up with
+// cleanSVG first so that svgdata can read them.
+func ReadSVG(fn string) (*svgdata.Root, error) {
+	d, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	d, err = cleanSVG(d)
+	if err != nil {
+		return nil, err
+	}
+	return svgdata.Unmarshal(d)
+}
+
+// cleanSVG rewrites an SVG document into a form that svgdata can read.
+// svgdata fails on elements outside of the SVG namespace (like the ones
+// Inkscape adds) and complains about every namespaced attribute.  Foreign
+// elements are dropped, namespaced attributes keep just their local name and
+// comments and processing instructions are removed.
+func cleanSVG(data []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	b := bytes.Buffer{}
+	e := xml.NewEncoder(&b)
+
+	var open []xml.Name
+	skip := 0
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tt := t.(type) {
+		case xml.StartElement:
+			if skip > 0 || tt.Name.Space != svgdata.SvgNs {
+				skip++
+				continue
+			}
+
+			name := xml.Name{Local: tt.Name.Local}
+			if len(open) == 0 {
+				name.Space = svgdata.SvgNs
+			}
+			se := xml.StartElement{Name: name}
+			seen := map[string]bool{}
+			for _, a := range tt.Attr {
+				if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" || seen[a.Name.Local] {
+					continue
+				}
+				seen[a.Name.Local] = true
+				se.Attr = append(se.Attr, xml.Attr{Name: xml.Name{Local: a.Name.Local}, Value: a.Value})
+			}
+			// svgdata requires these even though SVG defaults them to 0.
+			if name.Local == "circle" {
+				for _, k := range []string{"cx", "cy", "r"} {
+					if !seen[k] {
+						se.Attr = append(se.Attr, xml.Attr{Name: xml.Name{Local: k}, Value: "0"})
+					}
+				}
+			}
+
+			if err := e.EncodeToken(se); err != nil {
+				return nil, err
+			}
+			open = append(open, name)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if err := e.EncodeToken(xml.EndElement{Name: open[len(open)-1]}); err != nil {
+				return nil, err
+			}
+			open = open[:len(open)-1]
+		case xml.CharData:
+			if skip > 0 || len(open) == 0 {
+				continue
+			}
+			if err := e.EncodeToken(tt.Copy()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
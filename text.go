@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// TextOptions renders text, such as a name or a quote, in place of an image.
+type TextOptions struct {
+	// The text.  Lines are separated by newlines.
+	Text string `json:"text"`
+
+	// A TrueType (.ttf) or OpenType (.otf) font file.  Relative paths are
+	// relative to the job file.
+	Font string `json:"font"`
+
+	// The size of the font (its em square) in inches.  The default is as big
+	// as fits in the canvas.
+	Size float64 `json:"size,omitempty"`
+
+	// "left", "center" (default) or "right".
+	Align string `json:"align,omitempty"`
+
+	// "top", "middle" (default) or "bottom".
+	VAlign string `json:"valign,omitempty"`
+
+	// The distance between lines as a multiple of the font's line height.  The
+	// default is 1.
+	LineSpacing float64 `json:"lineSpacing,omitempty"`
+}
+
+// Validate checks the settings.  The font is only read when rendering.
+func (to *TextOptions) Validate() error {
+	if strings.TrimSpace(to.Text) == "" {
+		return fmt.Errorf("no text to render")
+	}
+	if to.Font == "" {
+		return fmt.Errorf("text needs a font file")
+	}
+	if to.Size < 0 {
+		return fmt.Errorf("text size must not be negative")
+	}
+	if to.LineSpacing < 0 {
+		return fmt.Errorf("text line spacing must not be negative")
+	}
+	switch to.Align {
+	case "", "left", "center", "right":
+	default:
+		return fmt.Errorf("unknown text align %q", to.Align)
+	}
+	switch to.VAlign {
+	case "", "top", "middle", "bottom":
+	default:
+		return fmt.Errorf("unknown text valign %q", to.VAlign)
+	}
+	return nil
+}
+
+func (to *TextOptions) lineSpacing() float64 {
+	if to.LineSpacing == 0 {
+		return 1
+	}
+	return to.LineSpacing
+}
+
+// textEdge is one straight piece of a glyph outline, in inches from the top
+// left of the canvas.
+type textEdge struct {
+	x0, y0, x1, y1 float64
+}
+
+// TextContent is text filled in dark on a light background.  Each cell's
+// value is how much of it is covered by the text.
+type TextContent struct {
+	// The size of the area the text is laid out in, in inches.
+	width, height float64
+	edges         []textEdge
+
+	w, h  int
+	cover []float64
+}
+
+// textSamples is the number of samples taken along each side of a cell.
+const textSamples = 4
+
+// NewTextContent lays out the text in to over an area width by height
+// inches.
+func NewTextContent(to *TextOptions, width, height float64) (*TextContent, error) {
+	f, err := loadFont(to.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	// Measure each line in font units.
+	lines := strings.Split(strings.Replace(to.Text, "\r\n", "\n", -1), "\n")
+	glyphs := make([][]sfnt.GlyphIndex, len(lines))
+	widths := make([]float64, len(lines))
+	maxWidth := 0.0
+	for i, l := range lines {
+		for _, r := range l {
+			g := f.glyphIndex(r)
+			glyphs[i] = append(glyphs[i], g)
+			widths[i] += f.advance(g)
+		}
+		maxWidth = math.Max(maxWidth, widths[i])
+	}
+	if maxWidth == 0 {
+		return nil, fmt.Errorf("the text has nothing to draw")
+	}
+	lineHeight := (f.ascent - f.descent + f.lineGap) * to.lineSpacing()
+	blockHeight := float64(len(lines)-1)*lineHeight + f.ascent - f.descent
+
+	// Inches per font unit.
+	scale := to.Size / f.unitsPerEm
+	if to.Size == 0 {
+		scale = math.Min(width/maxWidth, height/blockHeight)
+	}
+
+	top := (height - blockHeight*scale) / 2
+	switch to.VAlign {
+	case "top":
+		top = 0
+	case "bottom":
+		top = height - blockHeight*scale
+	}
+
+	tc := &TextContent{width: width, height: height}
+	for i := range lines {
+		x := (width - widths[i]*scale) / 2
+		switch to.Align {
+		case "left":
+			x = 0
+		case "right":
+			x = width - widths[i]*scale
+		}
+		baseline := top + (f.ascent+float64(i)*lineHeight)*scale
+
+		for _, g := range glyphs[i] {
+			contours, err := f.contours(g)
+			if err != nil {
+				return nil, err
+			}
+			for _, pts := range contours {
+				for j := range pts {
+					a, b := pts[j], pts[(j+1)%len(pts)]
+					if a.y == b.y {
+						continue
+					}
+					tc.edges = append(tc.edges, textEdge{
+						x0: x + a.x*scale, y0: baseline - a.y*scale,
+						x1: x + b.x*scale, y1: baseline - b.y*scale,
+					})
+				}
+			}
+			x += f.advance(g) * scale
+		}
+	}
+	return tc, nil
+}
+
+func (tc *TextContent) SetSize(w, h int) {
+	tc.w, tc.h = w, h
+	tc.cover = make([]float64, w*h)
+
+	// Fill the outlines with the nonzero winding rule, textSamples rows of
+	// samples to a cell.
+	sw := tc.width / float64(w*textSamples)
+	sh := tc.height / float64(h*textSamples)
+	type crossing struct {
+		x       float64
+		winding int
+	}
+	crossings := []crossing{}
+	for row := 0; row < h*textSamples; row++ {
+		sy := (float64(row) + 0.5) * sh
+		crossings = crossings[:0]
+		for _, e := range tc.edges {
+			winding := 1
+			y0, y1 := e.y0, e.y1
+			if y0 > y1 {
+				y0, y1, winding = y1, y0, -1
+			}
+			if sy < y0 || sy >= y1 {
+				continue
+			}
+			x := e.x0 + (sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0)
+			crossings = append(crossings, crossing{x, winding})
+		}
+		sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+		winding := 0
+		for i, c := range crossings {
+			winding += c.winding
+			if winding == 0 || i+1 == len(crossings) {
+				continue
+			}
+			// Fill the samples whose centers are between this crossing and the
+			// next.
+			from := int(math.Ceil(c.x/sw - 0.5))
+			to := int(math.Ceil(crossings[i+1].x/sw - 0.5))
+			if from < 0 {
+				from = 0
+			}
+			if to > w*textSamples {
+				to = w * textSamples
+			}
+			y := row / textSamples
+			for col := from; col < to; col++ {
+				tc.cover[y*w+col/textSamples]++
+			}
+		}
+	}
+	for i := range tc.cover {
+		tc.cover[i] /= textSamples * textSamples
+	}
+}
+
+func (tc *TextContent) GetValue(x, y int) float64 {
+	return tc.cover[y*tc.w+x]
+}
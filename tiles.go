@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/jbeda/geom"
+	svgdata "github.com/jbeda/svgdata-go"
+)
+
+// TileOptions splits a canvas that is bigger than the board into board sized
+// tiles.  The seams between tiles fall between cells so no circle is split.
+// Where each seam meets the canvas margin, and in the middle of each stretch of
+// seam between two tiles, there is a half round notch on either side for an
+// alignment pin.  Circles by a seam or a notch are made smaller so that there
+// is still the margin between them and the cut.
+type TileOptions struct {
+	// The space, in inches, between each tile and the edge of its board.  The
+	// default is 0.25.
+	Margin float64 `json:"margin,omitempty"`
+
+	// The radius, in inches, of the alignment notches.  The default is a
+	// quarter of the canvas margin.
+	AlignRadius float64 `json:"alignRadius,omitempty"`
+}
+
+// Validate checks that tiles with at least one cell can be cut from the board.
+func (to *TileOptions) Validate(p Params) error {
+	if to.Margin < 0 {
+		return fmt.Errorf("tile margin must not be negative")
+	}
+	if r := to.alignRadius(p); r <= 0 || r >= p.CanvasMargin/2 {
+		return fmt.Errorf("align radius %g must be more than 0 and less than half the canvas margin (%g)", r, p.CanvasMargin/2)
+	}
+	w, h := to.usable(p)
+	if min := p.CanvasMargin + 2*p.Space; w < min || h < min {
+		return fmt.Errorf("board (%gx%g) is too small for tiles with a margin of %g", p.BoardWidth, p.BoardHeight, to.margin())
+	}
+	return nil
+}
+
+func (to *TileOptions) margin() float64 {
+	if to.Margin == 0 {
+		return 0.25
+	}
+	return to.Margin
+}
+
+func (to *TileOptions) alignRadius(p Params) float64 {
+	if to.AlignRadius == 0 {
+		return p.CanvasMargin / 4
+	}
+	return to.AlignRadius
+}
+
+// usable returns the largest tile that fits on the board.
+func (to *TileOptions) usable(p Params) (float64, float64) {
+	return p.BoardWidth - 2*to.margin(), p.BoardHeight - 2*to.margin()
+}
+
+// tile is one piece of a tiled canvas.
+type tile struct {
+	name string
+	// The part of the canvas the tile covers.
+	rect geom.Rect
+	// The centers of the alignment notches, in canvas coordinates.
+	notches []geom.Coord
+}
+
+// gapLine returns the line halfway between the centers of cell i-1 and cell
+// i, for cells space apart.  cellSpace and margin place the cells the same way
+// that Layout does.
+func gapLine(i int, space, cellSpace, margin float64) float64 {
+	return margin + cellSpace/2 - space/2 + float64(i)*space
+}
+
+// splitAxis splits num cells, space apart, into as few tiles as fit in usable.
+// It returns the edges of the tiles from 0 to total.
+func splitAxis(num int, space, cellSpace, margin, total, usable float64) ([]float64, error) {
+	edge := func(i int) float64 {
+		switch i {
+		case 0:
+			return 0
+		case num:
+			return total
+		}
+		return gapLine(i, space, cellSpace, margin)
+	}
+
+	for n := 1; n <= num; n++ {
+		edges := make([]float64, n+1)
+		for i := range edges {
+			edges[i] = edge((num*i + n/2) / n)
+		}
+		fits := true
+		for i := 0; i < n; i++ {
+			if edges[i+1]-edges[i] > usable {
+				fits = false
+			}
+		}
+		if fits {
+			return edges, nil
+		}
+	}
+	return nil, fmt.Errorf("can't split %g inches into tiles of %g inches", total, usable)
+}
+
+// split works out the tiles for the canvas of sg, which must be laid out with
+// the canvas at the origin, to fit on the board of board.
+func (to *TileOptions) split(sg *SVGGrid, board Params) ([]tile, error) {
+	p := sg.p
+	uw, uh := to.usable(board)
+	xs, err := splitAxis(sg.xNum, sg.xSpace, p.Space, p.CanvasMargin, p.CanvasWidth, uw)
+	if err != nil {
+		return nil, err
+	}
+	ys, err := splitAxis(sg.yNum, sg.ySpace, p.Space, p.CanvasMargin, p.CanvasHeight, uh)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each seam gets a notch where it crosses the margin at either end.
+	notches := []geom.Coord{}
+	for _, x := range xs[1 : len(xs)-1] {
+		notches = append(notches, geom.Coord{X: x, Y: p.CanvasMargin / 2}, geom.Coord{X: x, Y: p.CanvasHeight - p.CanvasMargin/2})
+	}
+	for _, y := range ys[1 : len(ys)-1] {
+		notches = append(notches, geom.Coord{X: p.CanvasMargin / 2, Y: y}, geom.Coord{X: p.CanvasWidth - p.CanvasMargin/2, Y: y})
+	}
+
+	// Each stretch of seam between two tiles also gets a notch near its
+	// middle, where the gaps between the cells cross, so that tiles in the
+	// middle of the canvas are lined up too.
+	middle := func(from, to, space float64, num int) (float64, bool) {
+		i := int(math.Floor(((from+to)/2-gapLine(0, space, p.Space, p.CanvasMargin))/space + 0.5))
+		i = int(math.Max(1, math.Min(float64(num-1), float64(i))))
+		at := gapLine(i, space, p.Space, p.CanvasMargin)
+		return at, at > from && at < to
+	}
+	for _, x := range xs[1 : len(xs)-1] {
+		for row := 0; row < len(ys)-1; row++ {
+			if y, ok := middle(ys[row], ys[row+1], sg.ySpace, sg.yNum); ok {
+				notches = append(notches, geom.Coord{X: x, Y: y})
+			}
+		}
+	}
+	for _, y := range ys[1 : len(ys)-1] {
+		for col := 0; col < len(xs)-1; col++ {
+			if x, ok := middle(xs[col], xs[col+1], sg.xSpace, sg.xNum); ok {
+				notches = append(notches, geom.Coord{X: x, Y: y})
+			}
+		}
+	}
+
+	tiles := []tile{}
+	for row := 0; row < len(ys)-1; row++ {
+		for col := 0; col < len(xs)-1; col++ {
+			t := tile{
+				name: fmt.Sprintf("r%dc%d", row+1, col+1),
+				rect: geom.Rect{Min: geom.Coord{X: xs[col], Y: ys[row]}, Max: geom.Coord{X: xs[col+1], Y: ys[row+1]}},
+			}
+			for _, n := range notches {
+				onX := (n.X == t.rect.Min.X || n.X == t.rect.Max.X) && n.Y > t.rect.Min.Y && n.Y < t.rect.Max.Y
+				onY := (n.Y == t.rect.Min.Y || n.Y == t.rect.Max.Y) && n.X > t.rect.Min.X && n.X < t.rect.Max.X
+				if onX || onY {
+					t.notches = append(t.notches, n)
+				}
+			}
+			tiles = append(tiles, t)
+		}
+	}
+	return tiles, nil
+}
+
+// clearance returns how far c, in canvas coordinates, is from the nearest seam
+// with another tile inside a canvas of width w and height h, and from the
+// nearest of the tile's notches.
+func (t *tile) clearance(c geom.Coord, w, h, alignRadius float64) (seam, notch float64) {
+	d := math.Inf(1)
+	if t.rect.Min.X > 0 {
+		d = math.Min(d, c.X-t.rect.Min.X)
+	}
+	if t.rect.Max.X < w {
+		d = math.Min(d, t.rect.Max.X-c.X)
+	}
+	if t.rect.Min.Y > 0 {
+		d = math.Min(d, c.Y-t.rect.Min.Y)
+	}
+	if t.rect.Max.Y < h {
+		d = math.Min(d, t.rect.Max.Y-c.Y)
+	}
+	notch = math.Inf(1)
+	for _, n := range t.notches {
+		notch = math.Min(notch, c.DistanceFrom(n)-alignRadius)
+	}
+	return d, notch
+}
+
+// tileInfo is the extra information for writing a single tile.  Positions are
+// in inches on the tile's board.
+type tileInfo struct {
+	tile
+	offset      geom.Coord // where the tile's canvas origin is on its board
+	alignRadius float64
+}
+
+// tileOutline returns the outline of the tile, cut clockwise with a half round
+// notch into the tile at each alignment point.
+func (sg *SVGGrid) tileOutline() *svgdata.Path {
+	ti := sg.tile
+	min := ti.offset
+	max := ti.offset.Plus(ti.rect.Max.Minus(ti.rect.Min))
+	corners := []geom.Coord{min, {X: max.X, Y: min.Y}, max, {X: min.X, Y: max.Y}}
+	r := sg.scaleValue(ti.alignRadius)
+
+	cmds := []svgdata.PathCommand{pathCommand('M', sg.scaleValue(min.X), sg.scaleValue(min.Y))}
+	for i, from := range corners {
+		to := corners[(i+1)%len(corners)]
+		dir := to.Minus(from).Times(1 / to.DistanceFrom(from))
+
+		// The notches along this edge in the order they are reached.
+		along := []float64{}
+		for _, n := range ti.notches {
+			n = n.Minus(ti.rect.Min).Plus(ti.offset)
+			d := n.Minus(from)
+			if math.Abs(d.X*dir.Y-d.Y*dir.X) < 1e-9 {
+				along = append(along, d.X*dir.X+d.Y*dir.Y)
+			}
+		}
+		sort.Float64s(along)
+
+		for _, a := range along {
+			n := sg.scaleCoord(from.Plus(dir.Times(a)))
+			in, out := n.Minus(dir.Times(r)), n.Plus(dir.Times(r))
+			cmds = append(cmds,
+				pathCommand('L', in.X, in.Y),
+				pathCommand('A', r, r, 0, 0, 0, out.X, out.Y))
+		}
+		if i < len(corners)-1 {
+			cmds = append(cmds, pathCommand('L', sg.scaleValue(to.X), sg.scaleValue(to.Y)))
+		}
+	}
+	cmds = append(cmds, svgdata.PathCommand{Command: 'Z'})
+
+	path := svgdata.NewPath()
+	path.SubPaths = []svgdata.SubPath{{Commands: cmds}}
+	return path
+}
+
+// renderTiles lays out gc over the whole canvas and writes one SVG per tile
+// along with a map showing how the tiles fit together.
+func renderTiles(job *Job, sg *SVGGrid, gc GridContent, outputPrefix string) ([]Circle, error) {
+	circles := sg.Layout(gc)
+	tiles, err := job.Tiles.split(sg, job.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tiles {
+		tsg := *sg
+		tsg.p = job.Params
+		tsg.p.CanvasWidth = t.rect.Width()
+		tsg.p.CanvasHeight = t.rect.Height()
+		xOffset, yOffset := tsg.canvasOffset()
+		tsg.tile = &tileInfo{
+			tile:        t,
+			offset:      geom.Coord{X: xOffset, Y: yOffset},
+			alignRadius: job.Tiles.alignRadius(job.Params),
+		}
+
+		// Circles too close to a seam or a notch are made smaller, or left
+		// out if they would be smaller than the smallest circle.  The circles
+		// on either side of a seam each keep half the margin from it, so that
+		// across the seam they are as far apart as anywhere else.
+		tc := []Circle{}
+		shrunk := 0
+		for _, c := range circles {
+			if c.Center.X > t.rect.Min.X && c.Center.X < t.rect.Max.X && c.Center.Y > t.rect.Min.Y && c.Center.Y < t.rect.Max.Y {
+				seam, notch := t.clearance(c.Center, sg.p.CanvasWidth, sg.p.CanvasHeight, tsg.tile.alignRadius)
+				if r := math.Min(seam-sg.p.Margin/2, notch-sg.p.Margin); c.Radius > r {
+					shrunk++
+					if r < sg.p.MinRadius {
+						continue
+					}
+					c.Radius = r
+				}
+				c.Center = c.Center.Minus(t.rect.Min).Plus(tsg.tile.offset)
+				tc = append(tc, c)
+			}
+		}
+
+		tilePrefix := fmt.Sprintf("%s-%s", outputPrefix, t.name)
+		tsg.WriteSVG(tc, tilePrefix)
+		fmt.Printf("%s: %d circles, %d made smaller or left out by the seams\n", tilePrefix, len(tc), shrunk)
+		if err := writeOutputs(job, tc, tilePrefix); err != nil {
+			return nil, err
+		}
+	}
+
+	sg.writeTileMap(tiles, circles, fmt.Sprintf("%s-tiles", outputPrefix))
+	fmt.Printf("%d tiles, %d circles, %d skipped\n", len(tiles), len(circles), sg.Cells()-len(circles))
+	return circles, nil
+}
+
+// writeTileMap writes an overview of the whole canvas, with the tiles outlined
+// and named, to <outputPrefix>.svg.  It is for reference and not for cutting.
+func (sg *SVGGrid) writeTileMap(tiles []tile, circles []Circle, outputPrefix string) {
+	r := sg.CreateRoot()
+	r.AddChild(newTextElement("title", fmt.Sprintf("circle-art: %s", outputPrefix)))
+	r.AddChild(newTextElement("desc", fmt.Sprintf("Map of %d tiles covering a %gin x %gin canvas", len(tiles), sg.p.CanvasWidth, sg.p.CanvasHeight)))
+
+	fontSize := math.Inf(1)
+	for _, t := range tiles {
+		fontSize = math.Min(fontSize, math.Min(t.rect.Width(), t.rect.Height())/4)
+	}
+	style := svgdata.NewStyle()
+	style.Attrs()["type"] = "text/css"
+	style.SetText(fmt.Sprintf(".preview{fill:#bbbbbb;stroke:none;}\n.tile{fill:none;stroke:blue;stroke-width:%g;}\n.name{fill:blue;font-family:sans-serif;font-size:%gpx;text-anchor:middle;}\n",
+		sg.scaleValue(sg.p.StrokeWidth*4), sg.scaleValue(fontSize)))
+	r.AddChild(style)
+
+	preview := newLayer("preview", "Preview")
+	r.AddChild(preview)
+	for _, c := range circles {
+		circle := svgdata.NewCircle(sg.scaleCoord(c.Center), sg.scaleValue(c.Radius))
+		circle.Attrs()["class"] = "preview"
+		preview.AddChild(circle)
+	}
+
+	layer := newLayer("tiles", "Tiles")
+	r.AddChild(layer)
+	for _, t := range tiles {
+		outline := svgdata.NewRectXYWH(sg.scaleValue(t.rect.Min.X), sg.scaleValue(t.rect.Min.Y), sg.scaleValue(t.rect.Width()), sg.scaleValue(t.rect.Height()))
+		outline.Attrs()["class"] = "tile"
+		layer.AddChild(outline)
+
+		center := sg.scaleCoord(t.rect.Min.Plus(t.rect.Max).Times(0.5))
+		name := newTextElement("text", t.name)
+		name.Attrs()["class"] = "name"
+		name.Attrs()["x"] = fmt.Sprintf("%g", center.X)
+		name.Attrs()["y"] = fmt.Sprintf("%g", center.Y+sg.scaleValue(fontSize)/3)
+		layer.AddChild(name)
+	}
+
+	d, _ := svgdata.Marshal(r, true)
+	ioutil.WriteFile(fmt.Sprintf("%s.svg", outputPrefix), d, 0644)
+}
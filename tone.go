@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ToneOp is a single tone adjustment applied to the source image before it is
+// sampled.  Which fields are used depends on Op.
+type ToneOp struct {
+	// One of "levels", "gamma", "contrast", "brightness", "equalize" or "clahe"
+	Op string `json:"op"`
+
+	// levels: input values at or below Black become black and values at or
+	// above White become white.  Both are in the range 0 to 1.  A White of 0 is
+	// treated as 1.
+	Black float64 `json:"black,omitempty"`
+	White float64 `json:"white,omitempty"`
+
+	// gamma: values above 1 lighten the image, values below 1 darken it.
+	Gamma float64 `json:"gamma,omitempty"`
+
+	// contrast, brightness: a percentage in the range -100 to 100.
+	Amount float64 `json:"amount,omitempty"`
+
+	// clahe: the number of tiles along each axis (default 8) and the clip limit
+	// as a multiple of the average histogram bin (default 3).
+	Tiles     int     `json:"tiles,omitempty"`
+	ClipLimit float64 `json:"clipLimit,omitempty"`
+}
+
+// ToneChain is an ordered list of tone adjustments.
+type ToneChain []ToneOp
+
+// Apply runs each op in the chain in order over a grayscale image.
+func (tc ToneChain) Apply(img image.Image) (image.Image, error) {
+	return tc.ApplyContext(context.Background(), img)
+}
+
+// ApplyContext is Apply but stops early, returning ctx's error, once ctx is
+// done.
+func (tc ToneChain) ApplyContext(ctx context.Context, img image.Image) (image.Image, error) {
+	for _, op := range tc {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "levels":
+			img = levels(img, op.Black, op.White)
+		case "gamma":
+			if op.Gamma <= 0 {
+				return nil, fmt.Errorf("gamma must be positive: %g", op.Gamma)
+			}
+			img = imaging.AdjustGamma(img, op.Gamma)
+		case "contrast":
+			img = imaging.AdjustContrast(img, op.Amount)
+		case "brightness":
+			img = imaging.AdjustBrightness(img, op.Amount)
+		case "equalize":
+			img = equalize(img)
+		case "clahe":
+			var err error
+			img, err = clahe(ctx, img, op.Tiles, op.ClipLimit)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown tone op: %q", op.Op)
+		}
+	}
+	return img, nil
+}
+
+// applyLUT maps each color channel through lut, leaving alpha alone.
+func applyLUT(img image.Image, lut *[256]uint8) *image.NRGBA {
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{lut[c.R], lut[c.G], lut[c.B], c.A}
+	})
+}
+
+func clampUnit(f float64) float64 {
+	return math.Min(math.Max(f, 0), 1)
+}
+
+func levels(img image.Image, black, white float64) *image.NRGBA {
+	if white == 0 {
+		white = 1
+	}
+	if white <= black {
+		white = black + 1.0/255.0
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := clampUnit((float64(i)/255.0 - black) / (white - black))
+		lut[i] = uint8(v*255.0 + 0.5)
+	}
+	return applyLUT(img, &lut)
+}
+
+// equalizeLUT builds a lookup table that flattens the histogram h.  h is the
+// count (or probability) of each luminance value.
+func equalizeLUT(h *[256]float64) [256]uint8 {
+	var lut [256]uint8
+
+	total := 0.0
+	for _, v := range h {
+		total += v
+	}
+	if total == 0 {
+		for i := range lut {
+			lut[i] = uint8(i)
+		}
+		return lut
+	}
+
+	// The lowest occupied bin maps to black so the full output range is used.
+	cdfMin := 0.0
+	for _, v := range h {
+		if v != 0 {
+			cdfMin = v
+			break
+		}
+	}
+
+	cdf := 0.0
+	for i, v := range h {
+		cdf += v
+		if total == cdfMin {
+			lut[i] = uint8(i)
+			continue
+		}
+		lut[i] = uint8(clampUnit((cdf-cdfMin)/(total-cdfMin))*255.0 + 0.5)
+	}
+	return lut
+}
+
+func equalize(img image.Image) *image.NRGBA {
+	h := imaging.Histogram(img)
+	lut := equalizeLUT(&h)
+	return applyLUT(img, &lut)
+}
+
+// clahe does contrast limited adaptive histogram equalization. The image is
+// split into tiles x tiles regions that are each equalized with a clipped
+// histogram.  Each pixel is then mapped by bilinearly interpolating between the
+// lookup tables of the four nearest tiles.
+func clahe(ctx context.Context, img image.Image, tiles int, clipLimit float64) (*image.NRGBA, error) {
+	if tiles <= 0 {
+		tiles = 8
+	}
+	if clipLimit <= 0 {
+		clipLimit = 3
+	}
+
+	src := imaging.Clone(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w == 0 || h == 0 {
+		return src, nil
+	}
+	tx, ty := tiles, tiles
+	if tx > w {
+		tx = w
+	}
+	if ty > h {
+		ty = h
+	}
+	tileW := float64(w) / float64(tx)
+	tileH := float64(h) / float64(ty)
+
+	luts := make([][256]uint8, tx*ty)
+	for j := 0; j < ty; j++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for i := 0; i < tx; i++ {
+			x0, x1 := int(float64(i)*tileW), int(float64(i+1)*tileW)
+			y0, y1 := int(float64(j)*tileH), int(float64(j+1)*tileH)
+
+			var hist [256]float64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					hist[row[x*4]]++
+				}
+			}
+
+			// Clip the histogram and spread what was clipped evenly across all
+			// bins.  This limits how much noise in flat areas gets amplified.
+			limit := clipLimit * float64((x1-x0)*(y1-y0)) / 256.0
+			excess := 0.0
+			for k, v := range hist {
+				if v > limit {
+					excess += v - limit
+					hist[k] = limit
+				}
+			}
+			for k := range hist {
+				hist[k] += excess / 256.0
+			}
+
+			luts[j*tx+i] = equalizeLUT(&hist)
+		}
+	}
+
+	tileIndex := func(f float64, n int) (int, int, float64) {
+		f = math.Min(math.Max(f, 0), float64(n-1))
+		i0 := int(f)
+		i1 := i0 + 1
+		if i1 > n-1 {
+			i1 = n - 1
+		}
+		return i0, i1, f - float64(i0)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		j0, j1, fy := tileIndex((float64(y)+0.5)/tileH-0.5, ty)
+		for x := 0; x < w; x++ {
+			i0, i1, fx := tileIndex((float64(x)+0.5)/tileW-0.5, tx)
+
+			p := y*src.Stride + x*4
+			v := src.Pix[p]
+			top := (1-fx)*float64(luts[j0*tx+i0][v]) + fx*float64(luts[j0*tx+i1][v])
+			bottom := (1-fx)*float64(luts[j1*tx+i0][v]) + fx*float64(luts[j1*tx+i1][v])
+			out := uint8((1-fy)*top + fy*bottom + 0.5)
+
+			d := y*dst.Stride + x*4
+			dst.Pix[d+0] = out
+			dst.Pix[d+1] = out
+			dst.Pix[d+2] = out
+			dst.Pix[d+3] = src.Pix[p+3]
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// ttFont is a TrueType or OpenType font, read with sfnt.  Hinting, kerning
+// and shaping are ignored, which is fine at the sizes circle-art works at.
+type ttFont struct {
+	f   *sfnt.Font
+	buf sfnt.Buffer
+
+	// Glyphs are loaded at one pixel per font unit so that everything is in
+	// font units.
+	ppem fixed.Int26_6
+
+	unitsPerEm      float64
+	ascent, descent float64
+	lineGap         float64
+}
+
+// ttPoint is a point on a glyph outline in font units, with y going up.
+type ttPoint struct {
+	x, y float64
+}
+
+// ttCurveSteps is the number of straight lines each curve of a glyph is split
+// into.
+const ttCurveSteps = 8
+
+// loadFont reads the font in fn.  Only the first font of a collection is
+// used.
+func loadFont(fn string) (*ttFont, error) {
+	d, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseFont(d)
+	if err != nil {
+		return nil, fmt.Errorf("can't read font %s: %v", fn, err)
+	}
+	return f, nil
+}
+
+func parseFont(d []byte) (*ttFont, error) {
+	c, err := sfnt.ParseCollection(d)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := c.Font(0)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &ttFont{f: sf, unitsPerEm: float64(sf.UnitsPerEm())}
+	f.ppem = fixed.Int26_6(sf.UnitsPerEm()) << 6
+	m, err := sf.Metrics(&f.buf, f.ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	// sfnt measures the descent downwards.
+	f.ascent = fixedToFloat(m.Ascent)
+	f.descent = -fixedToFloat(m.Descent)
+	f.lineGap = fixedToFloat(m.Height) - f.ascent + f.descent
+	return f, nil
+}
+
+func fixedToFloat(v fixed.Int26_6) float64 {
+	return float64(v) / 64
+}
+
+// glyphIndex returns the glyph for r, or 0, the missing glyph.
+func (f *ttFont) glyphIndex(r rune) sfnt.GlyphIndex {
+	g, err := f.f.GlyphIndex(&f.buf, r)
+	if err != nil {
+		return 0
+	}
+	return g
+}
+
+// advance returns the advance width of glyph g in font units.
+func (f *ttFont) advance(g sfnt.GlyphIndex) float64 {
+	a, err := f.f.GlyphAdvance(&f.buf, g, f.ppem, font.HintingNone)
+	if err != nil {
+		return 0
+	}
+	return fixedToFloat(a)
+}
+
+// contours returns the outline of glyph g as closed polygons, with curves
+// split into straight lines.
+func (f *ttFont) contours(g sfnt.GlyphIndex) ([][]ttPoint, error) {
+	segs, err := f.f.LoadGlyph(&f.buf, g, f.ppem, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't load glyph %d: %v", g, err)
+	}
+
+	// sfnt's y axis goes down.
+	pt := func(p fixed.Point26_6) ttPoint {
+		return ttPoint{x: fixedToFloat(p.X), y: -fixedToFloat(p.Y)}
+	}
+	var contours [][]ttPoint
+	var cur []ttPoint
+	for _, s := range segs {
+		switch s.Op {
+		case sfnt.SegmentOpMoveTo:
+			if len(cur) > 2 {
+				contours = append(contours, cur)
+			}
+			cur = []ttPoint{pt(s.Args[0])}
+		case sfnt.SegmentOpLineTo:
+			cur = append(cur, pt(s.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			a, b, c := cur[len(cur)-1], pt(s.Args[0]), pt(s.Args[1])
+			for i := 1; i <= ttCurveSteps; i++ {
+				t := float64(i) / ttCurveSteps
+				u := 1 - t
+				cur = append(cur, ttPoint{
+					x: u*u*a.x + 2*u*t*b.x + t*t*c.x,
+					y: u*u*a.y + 2*u*t*b.y + t*t*c.y,
+				})
+			}
+		case sfnt.SegmentOpCubeTo:
+			a, b, c, d := cur[len(cur)-1], pt(s.Args[0]), pt(s.Args[1]), pt(s.Args[2])
+			for i := 1; i <= ttCurveSteps; i++ {
+				t := float64(i) / ttCurveSteps
+				u := 1 - t
+				cur = append(cur, ttPoint{
+					x: u*u*u*a.x + 3*u*u*t*b.x + 3*u*t*t*c.x + t*t*t*d.x,
+					y: u*u*u*a.y + 3*u*u*t*b.y + 3*u*t*t*c.y + t*t*t*d.y,
+				})
+			}
+		}
+	}
+	if len(cur) > 2 {
+		contours = append(contours, cur)
+	}
+	return contours, nil
+}
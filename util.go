@@ -15,15 +15,27 @@ func initColors(n int) []string {
 	return r
 }
 
+// initEngraveColors returns the fill colors for engraving passes.  They are
+// blue so they can't be mistaken for the cut colors.
+func initEngraveColors(n int) []string {
+	r := []string{}
+
+	for i := 0; i < n; i++ {
+		r = append(r, fmt.Sprintf("#0000%02x", int(scaleToRange(float64(i), float64(n), 32, 255))))
+	}
+	return r
+}
+
 // Scales a number between 0 and inMax proportionally to outMin and outMax
 func scaleToRange(in, inMax, outMin, outMax float64) float64 {
 	return outMin + (outMax-outMin)*(in/inMax)
 }
 
-func scaleValue(f float64) float64 {
-	return f * unitsPerInch
+// scaleValue converts inches to SVG units.
+func (sg *SVGGrid) scaleValue(f float64) float64 {
+	return f * sg.p.UnitsPerInch
 }
 
-func scaleCoord(c geom.Coord) geom.Coord {
-	return geom.Coord{scaleValue(c.X), scaleValue(c.Y)}
+func (sg *SVGGrid) scaleCoord(c geom.Coord) geom.Coord {
+	return geom.Coord{X: sg.scaleValue(c.X), Y: sg.scaleValue(c.Y)}
 }
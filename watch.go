@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// How often watched files are checked for changes.
+	watchInterval = 250 * time.Millisecond
+
+	// How long files must be left alone before rendering again.  Editors
+	// often save a file in several steps and this waits for the last one.
+	watchSettle = 500 * time.Millisecond
+)
+
+// fileState is what is checked to see if a file has changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	missing bool
+}
+
+func statFile(fn string) fileState {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return fileState{missing: true}
+	}
+	return fileState{modTime: fi.ModTime(), size: fi.Size()}
+}
+
+// jobFiles returns the files that job is made from.
+func jobFiles(job *Job, jobFile, compositeFile string) []string {
+	files := []string{}
+	for _, fn := range []string{jobFile, compositeFile} {
+		if fn != "" {
+			files = append(files, fn)
+		}
+	}
+	if job == nil {
+		return files
+	}
+	if job.Input != "" {
+		files = append(files, job.Input)
+	}
+	if job.Nest != nil {
+		for _, pc := range job.Nest.Pieces {
+			files = append(files, pc.Input)
+		}
+	}
+	if job.Composite != nil {
+		files = append(files, job.Composite.inputs()...)
+	}
+	if job.Text != nil {
+		files = append(files, job.Text.Font)
+	}
+	if job.Motif != nil {
+		files = append(files, job.Motif.File)
+	}
+	return files
+}
+
+// watchJob renders the job returned by load and then renders it again each
+// time the job file, the composition file or any of the inputs change.  Files
+// are polled, which works the same everywhere.  Errors are printed rather
+// than ending the watch so that a half saved file can be fixed.  It never
+// returns.
+func watchJob(load func() (*Job, string, error), jobFile, compositeFile string) {
+	var last *Stats
+	files := jobFiles(nil, jobFile, compositeFile)
+
+	snapshot := func() map[string]fileState {
+		s := map[string]fileState{}
+		for _, fn := range files {
+			s[fn] = statFile(fn)
+		}
+		return s
+	}
+
+	// render returns the state of the files from before they were read, so
+	// that anything saved while it runs is seen as a change.
+	render := func() map[string]fileState {
+		before := snapshot()
+		job, outputPrefix, err := load()
+		if job != nil {
+			files = jobFiles(job, jobFile, compositeFile)
+		}
+		seen := map[string]fileState{}
+		for _, fn := range files {
+			if st, ok := before[fn]; ok {
+				seen[fn] = st
+			} else {
+				seen[fn] = statFile(fn)
+			}
+		}
+
+		var circles []Circle
+		var sg *SVGGrid
+		if err == nil {
+			circles, err = renderJob(job, outputPrefix)
+		}
+		if err == nil {
+			sg, err = newJobGrid(job, job.Params)
+		}
+		now := time.Now().Format("15:04:05")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", now, err)
+			return seen
+		}
+
+		st := &Stats{Circles: len(circles), CutLength: sg.cutLength(circles)}
+		if last == nil {
+			fmt.Printf("%s: %d circles, %.1fin of cutting\n", now, st.Circles, st.CutLength)
+		} else {
+			fmt.Printf("%s: %d circles (%+d), %.1fin of cutting (%+.1fin)\n", now,
+				st.Circles, st.Circles-last.Circles, st.CutLength, st.CutLength-last.CutLength)
+		}
+		last = st
+		return seen
+	}
+
+	seen := render()
+	fmt.Printf("Watching %d files for changes\n", len(seen))
+	var changed time.Time
+	for {
+		time.Sleep(watchInterval)
+		now := snapshot()
+		for fn, st := range now {
+			if seen[fn] != st {
+				changed = time.Now()
+			}
+		}
+		seen = now
+		if changed.IsZero() || time.Since(changed) < watchSettle {
+			continue
+		}
+		changed = time.Time{}
+		// The files may be different now that the job has been reloaded.
+		seen = render()
+	}
+}